@@ -1,9 +1,18 @@
 package main
 
 import (
+	"expvar"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"messenger/internal/auth"
 	"messenger/internal/db"
 	"messenger/internal/handlers"
+	"messenger/internal/mail"
 	"messenger/internal/middleware"
+	"messenger/internal/pubsub"
 	"messenger/internal/websocket"
 
 	"github.com/gin-contrib/cors"
@@ -13,8 +22,22 @@ import (
 func main() {
 	db.Connect()
 
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+	mail.Default = mail.FromEnv()
+
 	r := gin.Default()
 
+	// 🛡️ Only nginx may say who the client is, login limits key on it
+	var proxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		proxies = strings.Split(v, ",")
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("trusted proxies: %v", err)
+	}
+
 	// 🔧 Explicit OPTIONS handling (dev)
 	r.Use(func(c *gin.Context) {
 		if c.Request.Method == "OPTIONS" {
@@ -33,11 +56,35 @@ func main() {
 	// 🔓 Public routes
 	r.POST("/register", handlers.Register)
 	r.POST("/login", handlers.Login)
+	r.POST("/login/2fa", handlers.LoginTOTP)
+	r.POST("/refresh", handlers.Refresh)
+	r.POST("/passkeys/login/begin", handlers.BeginPasskeyLogin)
+	r.POST("/passkeys/login/finish", handlers.FinishPasskeyLogin)
+	r.GET("/oidc/login", handlers.OIDCLogin)
+	r.GET("/oidc/callback", handlers.OIDCCallback)
+	r.POST("/verify-email", handlers.VerifyEmail)
+	r.POST("/forgot-password", handlers.ForgotPassword)
+	r.POST("/reset-password", handlers.ResetPassword)
+	r.GET("/api/account/exports/:id/download", handlers.DownloadDataExport) // signed link
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(200, auth.JWKS())
+	})
+
+	// 📡 Hub backplane: postgres when running more than one instance
+	var backplane pubsub.Backplane = pubsub.NewMemory()
+	if os.Getenv("HUB_BACKPLANE") == "postgres" {
+		backplane = pubsub.NewPostgres(db.DB, db.DSN)
+	}
 
 	// 🔌 WebSocket hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(backplane)
+	hub.OnPresence = handlers.BroadcastPresence
 	go hub.Run()
 
+	// 🗑️ Purge deleted accounts and expired data exports
+	go handlers.RunAccountDeletions()
+	go handlers.RunExportCleanup()
+
 	// ✅ WebSocket route (NO middleware)
 	r.GET("/api/ws", websocket.HandleWebSocket(hub))
 
@@ -46,20 +93,91 @@ func main() {
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.GET("/me", func(c *gin.Context) {
-			userID, _ := c.Get("user_id")
-			c.JSON(200, gin.H{"user_id": userID})
+			userID := c.GetString("user_id")
+
+			var username string
+			var deleteAfter *time.Time
+			db.DB.QueryRow(
+				`SELECT username, delete_after FROM users WHERE id = $1`,
+				userID,
+			).Scan(&username, &deleteAfter)
+
+			c.JSON(200, gin.H{"user_id": userID, "username": username, "delete_after": deleteAfter})
 		})
 		
+		protected.POST("/logout", handlers.Logout)
+		protected.POST("/email/resend-verification", handlers.ResendVerification)
+		protected.POST("/ws/ticket", handlers.CreateWSTicket)
+		protected.GET("/sessions", handlers.GetSessions)
+		protected.DELETE("/sessions/:id", handlers.RevokeSession)
+		protected.POST("/sessions/logout-others", handlers.LogoutOtherSessions)
+		protected.GET("/passkeys", handlers.GetPasskeys)
+		protected.POST("/passkeys/register/begin", handlers.BeginPasskeyRegistration)
+		protected.POST("/passkeys/register/finish", handlers.FinishPasskeyRegistration)
+		protected.DELETE("/passkeys/:id", handlers.DeletePasskey)
+		protected.POST("/2fa/enroll", handlers.EnrollTOTP)
+		protected.POST("/2fa/confirm", handlers.ConfirmTOTP)
+		protected.POST("/2fa/disable", handlers.DisableTOTP)
+
 		protected.GET("/chats/:chatId/messages", handlers.GetMessages)
+		protected.POST("/chats/:chatId/messages", handlers.SendMessage)
 		protected.POST("/chats", handlers.CreateChat)
+		protected.GET("/chats/saved", handlers.GetSavedChat)
+		protected.POST("/messages/:id/forward", handlers.ForwardMessage)
+		protected.POST("/media/:id/forward", handlers.ForwardMedia)
+		protected.POST("/messages/:id/star", handlers.StarMessage)
+		protected.DELETE("/messages/:id/star", handlers.UnstarMessage)
+		protected.POST("/media/:id/star", handlers.StarMedia)
+		protected.DELETE("/media/:id/star", handlers.UnstarMedia)
+		protected.GET("/starred", handlers.GetStarred)
 		protected.GET("/chats", handlers.GetChats)
-		protected.POST("/chats/:id/members", handlers.AddMember)
+		protected.POST("/chats/:chatId/members", handlers.AddMember)
+		protected.PUT("/chats/:chatId/settings", handlers.UpdateChatSettings)
+		protected.GET("/folders", handlers.GetFolders)
+		protected.POST("/folders", handlers.CreateFolder)
+		protected.PUT("/folders/:id", handlers.UpdateFolder)
+		protected.DELETE("/folders/:id", handlers.DeleteFolder)
 		protected.PUT("/profile/username", handlers.ChangeUsername)
 		protected.PUT("/profile/password", handlers.ChangePassword)
+		protected.PUT("/profile", handlers.UpdateProfile)
+		protected.PUT("/profile/status", handlers.SetStatus)
+		protected.DELETE("/profile/status", handlers.ClearStatus)
+		protected.PUT("/profile/avatar", handlers.UploadAvatar)
+		protected.DELETE("/profile/avatar", handlers.DeleteAvatar)
+		protected.GET("/users/search", handlers.SearchUsers)
+		protected.GET("/users/:id", handlers.GetUserProfile)
+		protected.GET("/users/:id/avatar", handlers.GetAvatar)
+		protected.GET("/contacts", handlers.GetContacts)
+		protected.POST("/contacts", handlers.AddContact)
+		protected.DELETE("/contacts/:id", handlers.RemoveContact)
+		protected.GET("/blocks", handlers.GetBlocks)
+		protected.POST("/blocks", handlers.BlockUser)
+		protected.DELETE("/blocks/:id", handlers.UnblockUser)
+		protected.GET("/settings/privacy", handlers.GetPrivacySettings)
+		protected.PUT("/settings/privacy", handlers.UpdatePrivacySettings)
+		protected.POST("/account/delete", handlers.DeleteAccount)
+		protected.POST("/account/cancel-deletion", handlers.CancelAccountDeletion)
+		protected.POST("/account/exports", handlers.RequestDataExport)
+		protected.GET("/account/exports/:id", handlers.GetDataExport)
 		protected.POST("/media", handlers.UploadMedia)
 		protected.GET("/media/:id", handlers.DownloadMedia)
 
+		// 🛟 Fallback transports for proxies that block WebSocket upgrades
+		protected.GET("/events", websocket.HandleSSE(hub))
+		protected.GET("/poll", websocket.HandlePoll(hub))
+
+
+	}
+
+	// 🛡️ Admin routes
+	admin := r.Group("/api/admin")
+	admin.Use(middleware.AuthMiddleware(), middleware.AdminOnly())
+	{
+		admin.POST("/users/:id/unlock", handlers.UnlockUser)
+		admin.GET("/users/:id/failed-logins", handlers.GetFailedLogins)
 
+		// 📈 Runtime metrics (member cache hit rate etc.)
+		admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 	}
 
 	r.Run(":8080")
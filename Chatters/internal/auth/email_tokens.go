@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"messenger/internal/db"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+var ErrInvalidEmailToken = errors.New("invalid or expired token")
+
+// NewEmailToken creates a single-use token to be mailed to the user.
+func NewEmailToken(userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.DB.Exec(
+		`INSERT INTO email_tokens (token_hash, user_id, purpose, expires_at)
+		 VALUES ($1, $2, $3, now() + $4 * interval '1 second')`,
+		hashToken(token), userID, purpose, int(ttl.Seconds()),
+	)
+	return token, err
+}
+
+// ConsumeEmailToken burns the token and returns the user it was issued to.
+func ConsumeEmailToken(token, purpose string) (string, error) {
+	var userID string
+	err := db.DB.QueryRow(
+		`UPDATE email_tokens SET used_at = now()
+		 WHERE token_hash = $1
+		 AND purpose = $2
+		 AND used_at IS NULL
+		 AND expires_at > now()
+		 RETURNING user_id`,
+		hashToken(token), purpose,
+	).Scan(&userID)
+	if err != nil {
+		return "", ErrInvalidEmailToken
+	}
+	return userID, nil
+}
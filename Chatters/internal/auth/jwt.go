@@ -6,16 +6,19 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("CHANGE_THIS_SECRET")
+// AccessTokenTTL is kept short, clients renew through the refresh token.
+const AccessTokenTTL = 15 * time.Minute
 
-func GenerateToken(userID string) (string, error) {
+func GenerateToken(userID, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(72 * time.Hour).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.id
+	return token.SignedString(signingKey.private)
 }
 
 func ValidateToken(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
@@ -24,9 +27,8 @@ func ValidateToken(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		},
+		keyFunc,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
 	)
 
 	return token, claims, err
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// key is one signing or verification key, identified by the "kid" header.
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{} // nil for verify-only keys
+	public  interface{}
+}
+
+var (
+	signingKey *key
+	// every key a token may be signed with, by kid
+	verifyKeys = map[string]*key{}
+)
+
+// LoadKeys reads the token keys from the environment:
+//
+//	JWT_SECRET        HS256 secrets, comma separated; each is published
+//	                  under a kid derived from it, the first one signs
+//	JWT_KEYS_DIR      directory of <kid>.pem files, Ed25519 or RSA; private
+//	                  keys can sign, public keys only verify
+//	JWT_SIGNING_KID   kid used for new tokens
+//	JWT_DEV_INSECURE  set to 1 to run without keys, signing with a random
+//	                  secret that dies with the process
+//	URL_SIGNING_KEY   key for signed links, derived from JWT_SECRET if unset
+//
+// To rotate, add the new key, point JWT_SIGNING_KID at it and remove the old
+// one once AccessTokenTTL has passed. An HS256 secret is rotated by putting
+// the new one first in JWT_SECRET.
+func LoadKeys() error {
+	verifyKeys = map[string]*key{}
+	signingKey = nil
+
+	var secrets []string
+	for _, s := range strings.Split(os.Getenv("JWT_SECRET"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	dir := os.Getenv("JWT_KEYS_DIR")
+
+	if len(secrets) == 0 && dir == "" {
+		if os.Getenv("JWT_DEV_INSECURE") != "1" {
+			return errors.New("set JWT_SECRET or JWT_KEYS_DIR (or JWT_DEV_INSECURE=1 for development)")
+		}
+		log.Println("JWT_DEV_INSECURE: signing with a random secret, tokens won't survive a restart")
+		secret, err := randomToken()
+		if err != nil {
+			return err
+		}
+		secrets = []string{secret}
+	}
+
+	defaultKid := ""
+	for _, secret := range secrets {
+		k := &key{
+			id:      hsKid(secret),
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+		verifyKeys[k.id] = k
+		if defaultKid == "" {
+			defaultKid = k.id
+		}
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			k, err := loadPEM(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			verifyKeys[k.id] = k
+		}
+	}
+
+	kid := os.Getenv("JWT_SIGNING_KID")
+	if kid == "" {
+		kid = defaultKid
+	}
+	k, ok := verifyKeys[kid]
+	if !ok || k.private == nil {
+		return fmt.Errorf("no private key for JWT_SIGNING_KID %q", kid)
+	}
+	signingKey = k
+
+	firstSecret := ""
+	if len(secrets) > 0 {
+		firstSecret = secrets[0]
+	}
+	return loadURLKey(firstSecret)
+}
+
+// hsKid names an HS256 secret without giving anything away about it.
+func hsKid(secret string) string {
+	sum := sha256.Sum256([]byte("jwt kid\n" + secret))
+	return "hs-" + hex.EncodeToString(sum[:6])
+}
+
+func loadPEM(file string) (*key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	k := &key{id: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch pk := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, pk, pk.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, pk
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, pk, &pk.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, pk
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return k, nil
+}
+
+// keyFunc picks the verification key named by the token's kid and makes
+// sure the token uses that key's algorithm.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := verifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return k.public, nil
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set.
+// Shared HS256 secrets are never published.
+func JWKS() map[string]interface{} {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	keys := []map[string]string{}
+	for _, k := range verifyKeys {
+		switch pub := k.public.(type) {
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": k.id,
+				"alg": "EdDSA",
+				"use": "sig",
+				"x":   b64(pub),
+			})
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": k.id,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   b64(pub.N.Bytes()),
+				"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
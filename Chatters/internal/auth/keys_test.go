@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLoadKeysNeedsConfiguration(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_DEV_INSECURE", "")
+
+	if err := LoadKeys(); err == nil {
+		t.Fatal("LoadKeys without keys succeeded")
+	}
+
+	t.Setenv("JWT_DEV_INSECURE", "1")
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys with JWT_DEV_INSECURE: %v", err)
+	}
+	if string(signingKey.private.([]byte)) == "CHANGE_THIS_SECRET" {
+		t.Fatal("dev mode signs with a well-known secret")
+	}
+}
+
+func TestRotatedSecretKeepsOldTokensValid(t *testing.T) {
+	t.Setenv("JWT_SECRET", "old-secret")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := GenerateToken("u1", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := signingKey.id
+
+	t.Setenv("JWT_SECRET", "new-secret, old-secret")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if signingKey.id == oldKid {
+		t.Fatal("new secret got the old secret's kid")
+	}
+
+	if _, claims, err := ValidateToken(oldToken); err != nil || claims["user_id"] != "u1" {
+		t.Fatalf("old token after rotation: %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "new-secret")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateToken(oldToken); err == nil {
+		t.Fatal("token signed with a removed secret still validates")
+	}
+}
+
+func TestTokensNeedAKnownKid(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	// right secret, but no kid or one nobody published
+	for _, kid := range []string{"", "hs256"} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "u1"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, _ := token.SignedString([]byte("secret"))
+
+		if _, _, err := ValidateToken(signed); err == nil {
+			t.Fatalf("kid %q validates", kid)
+		}
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "ed1.pem"), pemData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_SECRET", "shared-secret")
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_SIGNING_KID", "ed1")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := JWKS()["keys"].([]map[string]string)
+	if len(keys) != 1 || keys[0]["kid"] != "ed1" || keys[0]["alg"] != "EdDSA" {
+		t.Fatalf("JWKS = %v", keys)
+	}
+
+	token, err := GenerateToken("u1", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := ValidateToken(token)
+	if err != nil || parsed.Header["kid"] != "ed1" {
+		t.Fatalf("ed25519 token: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"messenger/internal/db"
+)
+
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Device describes where a session was opened from.
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// CreateSession starts a login session and returns its id together with
+// the first refresh token.
+func CreateSession(userID string, device Device) (sessionID, refreshToken string, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO sessions (user_id, device_name, user_agent, ip)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id`,
+		userID, device.Name, device.UserAgent, device.IP,
+	).Scan(&sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = insertRefreshToken(tx, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return sessionID, refreshToken, tx.Commit()
+}
+
+// RotateRefreshToken trades a refresh token for a new one. A token can be
+// used once; presenting it again means it leaked, so the whole session is
+// revoked and its ids are returned along with ErrInvalidRefreshToken.
+func RotateRefreshToken(refreshToken string) (userID, sessionID, newToken string, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	var expired bool
+	var usedAt, revokedAt sql.NullTime
+
+	err = tx.QueryRow(
+		`SELECT s.user_id, s.id, t.expires_at <= now(), t.used_at, s.revoked_at
+		 FROM refresh_tokens t
+		 JOIN sessions s ON s.id = t.session_id
+		 WHERE t.token_hash = $1
+		 FOR UPDATE OF t`,
+		hashToken(refreshToken),
+	).Scan(&userID, &sessionID, &expired, &usedAt, &revokedAt)
+	if err != nil {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+
+	if usedAt.Valid {
+		tx.Rollback()
+		RevokeSession(sessionID)
+		return userID, sessionID, "", ErrInvalidRefreshToken
+	}
+	if revokedAt.Valid || expired {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+
+	_, err = tx.Exec(
+		`UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1`,
+		hashToken(refreshToken),
+	)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE sessions SET last_used_at = now() WHERE id = $1`,
+		sessionID,
+	)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	newToken, err = insertRefreshToken(tx, sessionID)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return userID, sessionID, newToken, tx.Commit()
+}
+
+// SessionActive reports whether the user's session exists and wasn't
+// revoked.
+func SessionActive(sessionID, userID string) bool {
+	var active bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM sessions
+			WHERE id = $1 AND user_id::text = $2 AND revoked_at IS NULL
+		)`,
+		sessionID, userID,
+	).Scan(&active)
+	return active
+}
+
+// TouchSession records activity on the session, at most once a minute.
+func TouchSession(sessionID string) {
+	_, _ = db.DB.Exec(
+		`UPDATE sessions SET last_used_at = now()
+		 WHERE id = $1 AND last_used_at < now() - interval '1 minute'`,
+		sessionID,
+	)
+}
+
+func RevokeSession(sessionID string) error {
+	_, err := db.DB.Exec(
+		`UPDATE sessions SET revoked_at = now()
+		 WHERE id = $1 AND revoked_at IS NULL`,
+		sessionID,
+	)
+	return err
+}
+
+func RevokeUserSessions(userID string) error {
+	_, err := db.DB.Exec(
+		`UPDATE sessions SET revoked_at = now()
+		 WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+// RevokeOtherSessions ends every session of the user except keep.
+func RevokeOtherSessions(userID, keep string) error {
+	_, err := db.DB.Exec(
+		`UPDATE sessions SET revoked_at = now()
+		 WHERE user_id = $1 AND id != $2 AND revoked_at IS NULL`,
+		userID, keep,
+	)
+	return err
+}
+
+func insertRefreshToken(tx *sql.Tx, sessionID string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, expires_at)
+		 VALUES ($1, $2, now() + $3 * interval '1 second')`,
+		hashToken(token), sessionID, int(RefreshTokenTTL.Seconds()),
+	)
+	return token, err
+}
+
+// randomToken returns 256 random bits, URL safe.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
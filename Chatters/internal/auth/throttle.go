@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+
+	"messenger/internal/db"
+)
+
+// Failed logins are free up to a limit, after that every further failure
+// locks the key for twice as long as the previous one.
+const (
+	accountFreeAttempts = 5
+	ipFreeAttempts      = 20
+	lockoutBase         = 30 * time.Second
+	lockoutMax          = time.Hour
+	// failures older than this no longer count
+	failureWindow = time.Hour
+)
+
+func AccountKey(id string) string { return "user:" + id }
+func IPKey(ip string) string      { return "ip:" + ip }
+
+// LoginLocked returns how long the caller has to wait before trying again,
+// zero when none of the keys is locked.
+func LoginLocked(keys ...string) time.Duration {
+	var wait time.Duration
+	for _, key := range keys {
+		// the clock that wrote locked_until is the one to ask
+		var left sql.NullFloat64
+		db.DB.QueryRow(
+			`SELECT EXTRACT(EPOCH FROM locked_until - now())
+			 FROM login_throttle
+			 WHERE key = $1 AND locked_until > now()`,
+			key,
+		).Scan(&left)
+
+		if left.Valid {
+			if d := time.Duration(left.Float64 * float64(time.Second)); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// RecordLoginFailure counts a failure against the account and the client
+// address and keeps an audit record of it.
+func RecordLoginFailure(identifier, userID, ip, userAgent, reason string) {
+	accountKey := AccountKey(identifier)
+	if userID != "" {
+		accountKey = AccountKey(userID)
+	}
+	bump(accountKey, accountFreeAttempts)
+	bump(IPKey(ip), ipFreeAttempts)
+
+	_, _ = db.DB.Exec(
+		`INSERT INTO failed_logins (identifier, user_id, ip, user_agent, reason)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5)`,
+		identifier, userID, ip, userAgent, reason,
+	)
+}
+
+// ClearLoginFailures resets the account after a successful login or an
+// admin unlock.
+func ClearLoginFailures(userID string) error {
+	_, err := db.DB.Exec(
+		`DELETE FROM login_throttle WHERE key = $1`,
+		AccountKey(userID),
+	)
+	return err
+}
+
+func bump(key string, free int) {
+	var failures int
+	err := db.DB.QueryRow(
+		`INSERT INTO login_throttle (key, failures, last_failure)
+		 VALUES ($1, 1, now())
+		 ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.last_failure < now() - $2 * interval '1 second' THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			last_failure = now()
+		 RETURNING failures`,
+		key, failureWindow.Seconds(),
+	).Scan(&failures)
+	if err != nil || failures <= free {
+		return
+	}
+
+	lock := lockoutBase
+	for i := free + 1; i < failures && lock < lockoutMax; i++ {
+		lock *= 2
+	}
+	if lock > lockoutMax {
+		lock = lockoutMax
+	}
+
+	_, _ = db.DB.Exec(
+		`UPDATE login_throttle SET locked_until = now() + $2 * interval '1 second'
+		 WHERE key = $1`,
+		key, int(lock.Seconds()),
+	)
+}
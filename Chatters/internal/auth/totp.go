@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"messenger/internal/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RFC 6238 defaults, the only parameters authenticator apps reliably support
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// accepted clock drift, in periods
+	totpSkew = 1
+
+	totpIssuer = "Chatters"
+
+	// how long the password step of a 2FA login stays valid
+	ChallengeTTL = 5 * time.Minute
+)
+
+var (
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	ErrInvalidChallenge = errors.New("invalid challenge token")
+)
+
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// TOTPURI is the otpauth:// URI authenticator apps scan from a QR code.
+func TOTPURI(username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ValidateTOTP checks code against the secret around now and returns the
+// matching time step, so callers can refuse a step that was already used.
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		want := totpCode(key, step+i)
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// NewRecoveryCodes returns fresh codes and the hashes to store for them.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(b32.EncodeToString(buf))
+		code := raw[:4] + "-" + raw[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func HashRecoveryCode(code string) string {
+	return hashToken(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// GenerateChallengeToken is handed out after a correct password when the
+// account has 2FA on. It can't be used as an access token: it has no "sid".
+func GenerateChallengeToken(userID, deviceName string) (string, error) {
+	return newChallengeToken(userID, deviceName, time.Now())
+}
+
+func newChallengeToken(userID, deviceName string, now time.Time) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"typ":     "mfa",
+		"jti":     jti,
+		"user_id": userID,
+		"device":  deviceName,
+		"exp":     now.Add(ChallengeTTL).Unix(),
+	}
+
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.id
+	return token.SignedString(signingKey.private)
+}
+
+// ValidateChallengeToken checks the token without using it up, see
+// ConsumeChallengeToken.
+func ValidateChallengeToken(tokenString string) (userID, deviceName string, err error) {
+	userID, deviceName, _, err = parseChallengeToken(tokenString)
+	return userID, deviceName, err
+}
+
+// ConsumeChallengeToken burns the token once the second factor checked
+// out, so the same password step can't complete a second login.
+func ConsumeChallengeToken(tokenString string) error {
+	_, _, jti, err := parseChallengeToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	// entries only need to outlive the tokens they stand for
+	db.DB.Exec(`DELETE FROM used_challenges WHERE expires_at < now()`)
+
+	res, err := db.DB.Exec(
+		`INSERT INTO used_challenges (jti, expires_at)
+		 VALUES ($1, now() + $2 * interval '1 second')
+		 ON CONFLICT DO NOTHING`,
+		jti, int(ChallengeTTL.Seconds()),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidChallenge
+	}
+	return nil
+}
+
+func parseChallengeToken(tokenString string) (userID, deviceName, jti string, err error) {
+	token, claims, err := ValidateToken(tokenString)
+	if err != nil || !token.Valid || claims["typ"] != "mfa" {
+		return "", "", "", ErrInvalidChallenge
+	}
+
+	userID, _ = claims["user_id"].(string)
+	deviceName, _ = claims["device"].(string)
+	jti, _ = claims["jti"].(string)
+	if userID == "" || jti == "" {
+		return "", "", "", ErrInvalidChallenge
+	}
+	return userID, deviceName, jti, nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1, truncated to our six digits.
+func TestValidateTOTPMatchesRFC6238(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		step, ok := ValidateTOTP(secret, v.code, time.Unix(v.unix, 0))
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("t=%d code %s: step %d, ok %v", v.unix, v.code, step, ok)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsOneStepOfDrift(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	code := "005924" // step of t=1234567890
+
+	for _, offset := range []int64{-totpPeriod, 0, totpPeriod} {
+		if _, ok := ValidateTOTP(secret, code, time.Unix(1234567890+offset, 0)); !ok {
+			t.Errorf("rejected at offset %ds", offset)
+		}
+	}
+	for _, offset := range []int64{-3 * totpPeriod, 3 * totpPeriod} {
+		if _, ok := ValidateTOTP(secret, code, time.Unix(1234567890+offset, 0)); ok {
+			t.Errorf("accepted at offset %ds", offset)
+		}
+	}
+	if _, ok := ValidateTOTP(secret, "12345", time.Unix(1234567890, 0)); ok {
+		t.Error("accepted a short code")
+	}
+}
+
+func TestChallengeToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := GenerateChallengeToken("u1", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, device, err := ValidateChallengeToken(token)
+	if err != nil || userID != "u1" || device != "phone" {
+		t.Fatalf("fresh challenge: %q %q %v", userID, device, err)
+	}
+
+	expired, _ := newChallengeToken("u1", "phone", time.Now().Add(-ChallengeTTL-time.Minute))
+	if _, _, err := ValidateChallengeToken(expired); err != ErrInvalidChallenge {
+		t.Fatalf("expired challenge: %v", err)
+	}
+
+	// an access token is not a challenge, and the other way round
+	access, _ := GenerateToken("u1", "s1")
+	if _, _, err := ValidateChallengeToken(access); err != ErrInvalidChallenge {
+		t.Fatalf("access token as challenge: %v", err)
+	}
+	if _, claims, err := ValidateToken(token); err == nil && claims["sid"] != nil {
+		t.Fatal("challenge token carries a session")
+	}
+}
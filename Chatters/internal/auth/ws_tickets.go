@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"messenger/internal/db"
+)
+
+const WSTicketTTL = 30 * time.Second
+
+var ErrInvalidWSTicket = errors.New("invalid or expired ticket")
+
+// NewWSTicket mints a ticket that opens one WebSocket for the session.
+func NewWSTicket(sessionID string) (string, error) {
+	ticket, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	// 🧹 tickets are short-lived, drop the stale ones as we go
+	_, _ = db.DB.Exec(`DELETE FROM ws_tickets WHERE expires_at < now()`)
+
+	_, err = db.DB.Exec(
+		`INSERT INTO ws_tickets (ticket_hash, session_id, expires_at)
+		 VALUES ($1, $2, now() + $3 * interval '1 second')`,
+		hashToken(ticket), sessionID, int(WSTicketTTL.Seconds()),
+	)
+	return ticket, err
+}
+
+// RedeemWSTicket burns the ticket and returns who it was issued to, as
+// long as the session is still active.
+func RedeemWSTicket(ticket string) (userID, sessionID string, err error) {
+	err = db.DB.QueryRow(
+		`WITH t AS (
+		   DELETE FROM ws_tickets
+		   WHERE ticket_hash = $1
+		   RETURNING session_id, expires_at
+		 )
+		 SELECT s.user_id, s.id
+		 FROM t
+		 JOIN sessions s ON s.id = t.session_id
+		 WHERE t.expires_at > now()
+		 AND s.revoked_at IS NULL`,
+		hashToken(ticket),
+	).Scan(&userID, &sessionID)
+	if err != nil {
+		return "", "", ErrInvalidWSTicket
+	}
+	return userID, sessionID, nil
+}
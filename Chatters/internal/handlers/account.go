@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountDeletionGrace is how long a user can change their mind.
+const AccountDeletionGrace = 14 * 24 * time.Hour
+
+// reauthWindow is how recent a login has to be to stand in for a password.
+const reauthWindow = 10 * time.Minute
+
+// DeleteAccount schedules the account for deletion. Logging in and calling
+// CancelAccountDeletion within the grace period keeps it.
+func DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"` // TOTP or recovery code, when 2FA is on
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var hash string
+	var totpEnabled, freshSession bool
+	err := db.DB.QueryRow(
+		`SELECT u.password_hash, u.totp_enabled,
+		   EXISTS (
+		     SELECT 1 FROM sessions s
+		     WHERE s.id::text = $2 AND s.created_at > now() - $3 * interval '1 second'
+		   )
+		 FROM users u WHERE u.id = $1`,
+		userID, c.GetString("session_id"), int(reauthWindow.Seconds()),
+	).Scan(&hash, &totpEnabled, &freshSession)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	// 🔐 confirm it's really them: the password, or for single sign-on
+	// accounts without one a second factor or a login made just now
+	switch {
+	case hash != "":
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+			return
+		}
+	case totpEnabled:
+		if !checkSecondFactor(userID, req.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+	case !freshSession:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "log in again to delete your account"})
+		return
+	}
+
+	var deleteAfter time.Time
+	err = db.DB.QueryRow(
+		`UPDATE users SET delete_after = now() + $1 * interval '1 second'
+		 WHERE id = $2
+		 RETURNING delete_after`,
+		int(AccountDeletionGrace.Seconds()), userID,
+	).Scan(&deleteAfter)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "account scheduled for deletion",
+		"delete_after": deleteAfter,
+	})
+}
+
+func CancelAccountDeletion(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	_, err := db.DB.Exec(
+		`UPDATE users SET delete_after = NULL WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deletion cancelled"})
+}
+
+// RunAccountDeletions purges accounts whose grace period is over. Every
+// instance runs it; purgeAccount locks the row so each account is purged
+// once.
+func RunAccountDeletions() {
+	for {
+		rows, err := db.DB.Query(
+			`SELECT id FROM users WHERE delete_after < now()`,
+		)
+		if err != nil {
+			println("ACCOUNT DELETION ERROR:", err.Error())
+		} else {
+			var ids []string
+			for rows.Next() {
+				var id string
+				if rows.Scan(&id) == nil {
+					ids = append(ids, id)
+				}
+			}
+			rows.Close()
+
+			for _, id := range ids {
+				if err := purgeAccount(id); err != nil {
+					println("ACCOUNT DELETION ERROR:", id, err.Error())
+				}
+			}
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+// purgeAccount removes the user and everything that identifies them. Their
+// text messages stay in the chats with sender_id set to NULL. An account
+// that another instance is purging, or whose deletion was cancelled in the
+// meantime, is left alone.
+func purgeAccount(userID string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var due bool
+	err = tx.QueryRow(
+		`SELECT true FROM users
+		 WHERE id = $1 AND delete_after < now()
+		 FOR UPDATE SKIP LOCKED`,
+		userID,
+	).Scan(&due)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 1️⃣ Chats to refresh once the member is gone
+	var chatIDs []string
+	err = tx.QueryRow(
+		`SELECT COALESCE(ARRAY_AGG(chat_id::text), '{}')
+		 FROM chat_members WHERE user_id = $1`,
+		userID,
+	).Scan(pq.Array(&chatIDs))
+	if err != nil {
+		return err
+	}
+
+	// 2️⃣ Groups they own go to the longest-standing poster left, or any member
+	_, err = tx.Exec(
+		`UPDATE chats c SET owner_id = COALESCE(
+		   (SELECT m.sender_id FROM messages m
+		    JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = m.sender_id
+		    WHERE m.chat_id = c.id AND m.sender_id != $1
+		    ORDER BY m.created_at LIMIT 1),
+		   (SELECT user_id FROM chat_members
+		    WHERE chat_id = c.id AND user_id != $1 LIMIT 1)
+		 )
+		 WHERE c.owner_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// 3️⃣ Uploaded files, avatars and export archives go away with their rows
+	var files []string
+	err = tx.QueryRow(
+		`WITH d AS (
+		   DELETE FROM media_messages WHERE sender_id = $1 RETURNING file_path
+		 )
+		 SELECT COALESCE(ARRAY_AGG(DISTINCT file_path), '{}') FROM d
+		 -- forwards share the file, keep it while someone else's copy is left
+		 WHERE NOT EXISTS (
+		   SELECT 1 FROM media_messages m
+		   WHERE m.file_path = d.file_path AND m.sender_id IS DISTINCT FROM $1
+		 )`,
+		userID,
+	).Scan(pq.Array(&files))
+	if err != nil {
+		return err
+	}
+
+	var exports []string
+	err = tx.QueryRow(
+		`WITH d AS (
+		   DELETE FROM data_exports WHERE user_id = $1 AND file_path IS NOT NULL RETURNING file_path
+		 )
+		 SELECT COALESCE(ARRAY_AGG(file_path), '{}') FROM d`,
+		userID,
+	).Scan(pq.Array(&exports))
+	if err != nil {
+		return err
+	}
+	files = append(files, exports...)
+
+	var avatar, avatarThumb *string
+	err = tx.QueryRow(
+		`SELECT avatar_path, avatar_thumb_path FROM users WHERE id = $1`,
+		userID,
+	).Scan(&avatar, &avatarThumb)
+	if err != nil {
+		return err
+	}
+	for _, p := range []*string{avatar, avatarThumb} {
+		if p != nil {
+			files = append(files, *p)
+		}
+	}
+
+	// 4️⃣ Anonymize messages and the login audit trail
+	if _, err = tx.Exec(`UPDATE messages SET sender_id = NULL WHERE sender_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM failed_logins WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM login_throttle WHERE key = $1`, auth.AccountKey(userID)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM chat_members WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	// 5️⃣ Credentials, sessions, passkeys and identities cascade
+	if _, err = tx.Exec(`DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			println("ACCOUNT DELETION ERROR:", err.Error())
+		}
+	}
+
+	websocket.GlobalHub.DisconnectUser(userID)
+	websocket.GlobalHub.InvalidateUser(userID)
+	for _, chatID := range chatIDs {
+		websocket.GlobalHub.InvalidateChat(chatID)
+	}
+	return nil
+}
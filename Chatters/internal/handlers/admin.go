@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+func UnlockUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	if err := auth.ClearLoginFailures(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
+
+func GetFailedLogins(c *gin.Context) {
+	userID := c.Param("id")
+
+	rows, err := db.DB.Query(
+		`SELECT identifier, ip, user_agent, reason, created_at
+		 FROM failed_logins
+		 WHERE user_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT 100`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	type FailedLogin struct {
+		Identifier string    `json:"identifier"`
+		IP         string    `json:"ip"`
+		UserAgent  string    `json:"user_agent"`
+		Reason     string    `json:"reason"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	attempts := []FailedLogin{}
+	for rows.Next() {
+		var f FailedLogin
+		if err := rows.Scan(&f.Identifier, &f.IP, &f.UserAgent, &f.Reason, &f.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		attempts = append(attempts, f)
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
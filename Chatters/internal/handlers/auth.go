@@ -3,7 +3,9 @@ package handlers
 import (
 	"messenger/internal/auth"
 	"messenger/internal/db"
+	"messenger/internal/websocket"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -41,7 +43,7 @@ func Register(c *gin.Context) {
 	// 2️⃣ check username uniqueness (NO AUTO POSTFIX)
 	var usernameExists bool
 	db.DB.QueryRow(
-		`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`,
 		req.Username,
 	).Scan(&usernameExists)
 
@@ -62,20 +64,45 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 4️⃣ insert user
-	_, err = db.DB.Exec(
-		`INSERT INTO users (id, email, password_hash)
-		 VALUES ($1, $2, $3)`,
+	// 4️⃣ insert user together with their Saved Messages chat
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRow(
+		`INSERT INTO users (username, email, password_hash)
+		 VALUES ($1, $2, $3)
+		 RETURNING id`,
 		req.Username, req.Email, string(hash),
-	)
+	).Scan(&userID)
 
 	if err != nil {
 		c.JSON(500, gin.H{"error": "failed to create user"})
 		return
 	}
 
+	if _, err := createSavedChat(tx, userID); err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "failed to create user"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "failed to create user"})
+		return
+	}
+
+	// 5️⃣ verification mail, the account works without it
+	if err := sendVerificationEmail(userID, req.Username, req.Email); err != nil {
+		println("MAIL ERROR:", err.Error())
+	}
+
 	c.JSON(200, gin.H{
 		"status":   "ok",
+		"user_id":  userID,
 		"username": req.Username,
 	})
 }
@@ -84,6 +111,7 @@ func Login(c *gin.Context) {
 	var req struct {
 		Identifier string `json:"username"` // username OR email
 		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -93,19 +121,137 @@ func Login(c *gin.Context) {
 
 	var userID string
 	var hash string
+	var totpEnabled bool
 
 	err := db.DB.QueryRow(
-		`SELECT id, password_hash
+		`SELECT id, password_hash, totp_enabled
 		 FROM users
-		 WHERE id = $1 OR email = $1`,
+		 WHERE username = $1 OR email = $1`,
 		req.Identifier,
-	).Scan(&userID, &hash)
+	).Scan(&userID, &hash, &totpEnabled)
+
+	// 🧱 throttled accounts and addresses don't get to try the password
+	account := userID
+	if err != nil {
+		account = req.Identifier
+	}
+	if loginLocked(c, account) {
+		return
+	}
 
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		reason := "bad_password"
+		if err != nil {
+			reason = "unknown_user"
+		}
+		auth.RecordLoginFailure(req.Identifier, userID, c.ClientIP(), c.Request.UserAgent(), reason)
+
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	token, _ := auth.GenerateToken(userID)
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	// 🔐 2FA: password is right, the code comes in a second request
+	if totpEnabled {
+		challenge, err := auth.GenerateChallengeToken(userID, req.DeviceName)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "token error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required":    true,
+			"challenge_token": challenge,
+		})
+		return
+	}
+
+	auth.ClearLoginFailures(userID)
+	issueTokens(c, userID, req.DeviceName)
+}
+
+// loginLocked answers 429 when the account or the client address is locked
+// out after too many failures.
+func loginLocked(c *gin.Context, account string) bool {
+	wait := auth.LoginLocked(auth.AccountKey(account), auth.IPKey(c.ClientIP()))
+	if wait <= 0 {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later"})
+	return true
+}
+
+// issueTokens starts a new session for the requesting device and responds
+// with its access and refresh tokens.
+func issueTokens(c *gin.Context, userID, deviceName string) {
+	tokens, err := newSession(c, userID, deviceName)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func newSession(c *gin.Context, userID, deviceName string) (gin.H, error) {
+	sessionID, refreshToken, err := auth.CreateSession(userID, auth.Device{
+		Name:      deviceName,
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := auth.GenerateToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID, sessionID, refreshToken, err := auth.RotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		// reused token: the session was revoked, drop its sockets too
+		if sessionID != "" {
+			websocket.GlobalHub.DisconnectSession(userID, sessionID)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	token, _ := auth.GenerateToken(userID, sessionID)
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+	})
+}
+
+func Logout(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.GetString("session_id")
+
+	if err := auth.RevokeSession(sessionID); err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	websocket.GlobalHub.DisconnectSession(userID, sessionID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
 }
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAvatarBytes = 5 << 20
+	// larger images are refused rather than decoded
+	maxAvatarPixels = 4096 * 4096
+	avatarThumbSize = 128
+)
+
+// avatarTypes maps the file extensions avatars are stored under to the
+// Content-Type they're served with.
+var avatarTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+	".gif": "image/gif",
+}
+
+// UploadAvatar stores the image next to chat media in private_uploads and
+// a square thumbnail beside it.
+func UploadAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "file required"})
+		return
+	}
+	if file.Size > maxAvatarBytes {
+		c.JSON(400, gin.H{"error": "avatar too large"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "file required"})
+		return
+	}
+	defer src.Close()
+
+	// 🖼️ Only accept images we can decode, and not decompression bombs.
+	// The stored name comes from the decoded format, not the client.
+	cfg, format, err := image.DecodeConfig(src)
+	ext := "." + format
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	if err != nil || avatarTypes[ext] == "" || cfg.Width*cfg.Height > maxAvatarPixels {
+		c.JSON(400, gin.H{"error": "unsupported image"})
+		return
+	}
+	src.Seek(0, 0)
+	img, _, err := image.Decode(src)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "unsupported image"})
+		return
+	}
+
+	path, err := saveUpload(c, file, filepath.Join("avatars", userID), "avatar"+ext)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to save file"})
+		return
+	}
+	thumbPath := strings.TrimSuffix(path, ext) + "_thumb.jpg"
+	if err := writeThumbnail(thumbPath, img, avatarThumbSize); err != nil {
+		os.Remove(path)
+		c.JSON(500, gin.H{"error": "failed to save file"})
+		return
+	}
+
+	var oldPath, oldThumb *string
+	db.DB.QueryRow(
+		`SELECT avatar_path, avatar_thumb_path FROM users WHERE id = $1`,
+		userID,
+	).Scan(&oldPath, &oldThumb)
+
+	_, err = db.DB.Exec(
+		`UPDATE users SET avatar_path = $1, avatar_thumb_path = $2 WHERE id = $3`,
+		path, thumbPath, userID,
+	)
+	if err != nil {
+		os.Remove(path)
+		os.Remove(thumbPath)
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	removeFiles(oldPath, oldThumb)
+
+	go notifyProfileChange(userID)
+
+	p, _ := loadProfile(userID)
+	c.JSON(http.StatusOK, p)
+}
+
+func DeleteAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var oldPath, oldThumb *string
+	err := db.DB.QueryRow(
+		`UPDATE users u SET avatar_path = NULL, avatar_thumb_path = NULL
+		 FROM users old
+		 WHERE u.id = $1 AND old.id = u.id
+		 RETURNING old.avatar_path, old.avatar_thumb_path`,
+		userID,
+	).Scan(&oldPath, &oldThumb)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	removeFiles(oldPath, oldThumb)
+
+	go notifyProfileChange(userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "avatar removed"})
+}
+
+// GetAvatar serves the full image, or the thumbnail with ?size=thumb.
+func GetAvatar(c *gin.Context) {
+	ownerID := c.Param("id")
+	viewerID := c.GetString("user_id")
+
+	var path, thumbPath *string
+	var audience string
+	err := db.DB.QueryRow(
+		`SELECT avatar_path, avatar_thumb_path, photo_visibility FROM users WHERE id = $1`,
+		ownerID,
+	).Scan(&path, &thumbPath, &audience)
+	if err != nil || path == nil || !allowedBy(audience, ownerID, viewerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if ownerID != viewerID && blockedEitherWay(ownerID, viewerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	if c.Query("size") == "thumb" && thumbPath != nil {
+		path = thumbPath
+	}
+
+	// never let the browser guess, older uploads kept the client's extension
+	contentType := avatarTypes[strings.ToLower(filepath.Ext(*path))]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=300")
+	c.File(*path)
+}
+
+func removeFiles(paths ...*string) {
+	for _, p := range paths {
+		if p != nil {
+			os.Remove(*p)
+		}
+	}
+}
+
+// writeThumbnail crops img to a centered square and scales it down to
+// size x size by averaging the source pixels under each target pixel.
+func writeThumbnail(path string, img image.Image, size int) error {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	if side < size {
+		size = side
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, size, size))
+	for ty := 0; ty < size; ty++ {
+		sy0, sy1 := y0+ty*side/size, y0+(ty+1)*side/size
+		for tx := 0; tx < size; tx++ {
+			sx0, sx1 := x0+tx*side/size, x0+(tx+1)*side/size
+
+			var r, g, bl, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					// flatten transparency onto white, the thumbnail is a JPEG
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr + (0xffff - ca))
+					g += uint64(cg + (0xffff - ca))
+					bl += uint64(cb + (0xffff - ca))
+					n++
+				}
+			}
+			thumb.Set(tx, ty, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: 0xffff,
+			})
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return jpeg.Encode(f, thumb, &jpeg.Options{Quality: 85})
+}
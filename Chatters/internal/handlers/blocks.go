@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hasBlocked reports whether blocker has blocked blocked.
+func hasBlocked(blockerID, blockedID string) bool {
+	var blocked bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE blocker_id = $1 AND blocked_id = $2
+		)`,
+		blockerID, blockedID,
+	).Scan(&blocked)
+	return blocked
+}
+
+// blockedEitherWay reports whether either user has blocked the other.
+func blockedEitherWay(userID, otherID string) bool {
+	var blocked bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2)
+			OR (blocker_id = $2 AND blocked_id = $1)
+		)`,
+		userID, otherID,
+	).Scan(&blocked)
+	return blocked
+}
+
+func GetBlocks(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	rows, err := db.DB.Query(
+		`SELECT u.id, u.username, u.display_name
+		 FROM blocks b
+		 JOIN users u ON u.id = b.blocked_id
+		 WHERE b.blocker_id = $1
+		 ORDER BY b.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	blocked := []DirectoryEntry{}
+	for rows.Next() {
+		var u DirectoryEntry
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		blocked = append(blocked, u)
+	}
+
+	c.JSON(http.StatusOK, blocked)
+}
+
+func BlockUser(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"` // alternative to user_id
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.UserID == "") == (req.Username == "") {
+		c.JSON(400, gin.H{"error": "give either user_id or username"})
+		return
+	}
+
+	blockedID, err := findUser(req.UserID, req.Username)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "user does not exist"})
+		return
+	}
+	if blockedID == userID {
+		c.JSON(400, gin.H{"error": "cannot block yourself"})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`INSERT INTO blocks (blocker_id, blocked_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		userID, blockedID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	websocket.GlobalHub.InvalidateBlocks(blockedID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "user blocked", "user_id": blockedID})
+}
+
+func UnblockUser(c *gin.Context) {
+	userID := c.GetString("user_id")
+	blockedID := c.Param("id")
+
+	_, err := db.DB.Exec(
+		`DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id::text = $2`,
+		userID, blockedID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	websocket.GlobalHub.InvalidateBlocks(blockedID)
+
+	c.JSON(http.StatusOK, gin.H{"status": "user unblocked"})
+}
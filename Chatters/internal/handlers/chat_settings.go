@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPinnedChats caps how many chats a user can pin.
+const maxPinnedChats = 5
+
+// UpdateChatSettings changes how the chat shows up for the caller; omitted
+// fields are left alone. mute_for (seconds) limits a mute, without it the
+// chat stays muted until unmuted.
+func UpdateChatSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	chatID := c.Param("chatId")
+
+	var req struct {
+		Muted    *bool `json:"muted"`
+		MuteFor  int   `json:"mute_for"`
+		Archived *bool `json:"archived"`
+		Pinned   *bool `json:"pinned"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MuteFor < 0 {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// 🔒 Check chat membership
+	var ok bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM chat_members
+			WHERE chat_id = $1 AND user_id = $2
+		)`,
+		chatID, userID,
+	).Scan(&ok)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a chat member"})
+		return
+	}
+
+	if req.Pinned != nil && *req.Pinned {
+		var pinned int
+		db.DB.QueryRow(
+			`SELECT COUNT(*) FROM chat_settings
+			 WHERE user_id = $1 AND pinned_at IS NOT NULL AND chat_id != $2`,
+			userID, chatID,
+		).Scan(&pinned)
+		if pinned >= maxPinnedChats {
+			c.JSON(400, gin.H{"error": "too many pinned chats"})
+			return
+		}
+	}
+
+	// the end of a mute is worked out by the database so it compares
+	// against the same clock and zone as now() in the queries that read it
+	var muteFor *int
+	if req.Muted != nil && *req.Muted && req.MuteFor > 0 {
+		muteFor = &req.MuteFor
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO chat_settings (user_id, chat_id) VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		userID, chatID,
+	)
+	if err == nil {
+		_, err = db.DB.Exec(
+			`UPDATE chat_settings
+			 SET muted = COALESCE($3, muted),
+			     muted_until = CASE
+			       WHEN $3::boolean IS NULL THEN muted_until
+			       WHEN $4::int IS NULL THEN NULL
+			       ELSE now() + make_interval(secs => $4::int)
+			     END,
+			     archived = COALESCE($5, archived),
+			     pinned_at = CASE
+			       WHEN $6::boolean IS NULL THEN pinned_at
+			       WHEN $6 THEN COALESCE(pinned_at, now())
+			       ELSE NULL
+			     END
+			 WHERE user_id = $1 AND chat_id = $2`,
+			userID, chatID, req.Muted, muteFor, req.Archived, req.Pinned,
+		)
+	}
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if req.Muted != nil {
+		websocket.GlobalHub.InvalidateMutes(chatID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "chat settings updated"})
+}
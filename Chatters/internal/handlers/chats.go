@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 	"github.com/lib/pq"
 	"messenger/internal/db"
+	"messenger/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,7 +15,7 @@ func CreateChat(c *gin.Context) {
 	creator := c.GetString("user_id")
 
 	var req struct {
-		Members []string `json:"members"`
+		Members []string `json:"members"` // usernames
 		IsGroup bool     `json:"is_group"`
 	}
 
@@ -36,12 +39,17 @@ func CreateChat(c *gin.Context) {
 	defer tx.Rollback()
 
 	// 1️⃣ Create chat
+	var owner *string
+	if req.IsGroup {
+		owner = &creator
+	}
+
 	var chatID string
 	err = tx.QueryRow(
-		`INSERT INTO chats (is_group)
-		 VALUES ($1)
+		`INSERT INTO chats (is_group, owner_id)
+		 VALUES ($1, $2)
 		 RETURNING id`,
-		req.IsGroup,
+		req.IsGroup, owner,
 	).Scan(&chatID)
 	if err != nil {
 		println("DB ERROR:", err.Error())
@@ -49,30 +57,32 @@ func CreateChat(c *gin.Context) {
 		return
 	}
 
-	// 2️⃣ Build UNIQUE member set
+	// 2️⃣ Resolve usernames & build UNIQUE member set
 	memberSet := map[string]bool{}
 	memberSet[creator] = true
 
 	for _, m := range req.Members {
-		memberSet[m] = true
-	}
-
-	// 3️⃣ Validate users & insert members
-	for user := range memberSet {
-
-		// check user exists
-		var exists bool
+		var id string
 		err = tx.QueryRow(
-			`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`,
-			user,
-		).Scan(&exists)
+			`SELECT id FROM users WHERE username = $1`,
+			m,
+		).Scan(&id)
 
-		if err != nil || !exists {
-			println("DB ERROR:", err.Error())
-			c.JSON(400, gin.H{"error": "user does not exist: " + user})
+		if err != nil {
+			c.JSON(400, gin.H{"error": "user does not exist: " + m})
 			return
 		}
+		// 🚫 people who blocked the creator can't be pulled into a chat, and
+		// group_add decides who may put someone in a group
+		if hasBlocked(id, creator) || (req.IsGroup && !canAddToGroup(creator, id)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "cannot add user: " + m})
+			return
+		}
+		memberSet[id] = true
+	}
 
+	// 3️⃣ Insert members
+	for user := range memberSet {
 		_, err = tx.Exec(
 			`INSERT INTO chat_members (chat_id, user_id)
 			 VALUES ($1, $2)`,
@@ -91,25 +101,97 @@ func CreateChat(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "commit failed"})
 		return
 	}
+	websocket.GlobalHub.InvalidateChat(chatID)
 
 	c.JSON(200, gin.H{"chat_id": chatID})
 }
 
+// myChatsSQL selects the chats of user $1 together with their per-user
+// state, as "mine". Unread counts messages and media from others past the
+// user's read position, leaving out blocked senders.
+const myChatsSQL = `
+	mine AS (
+		SELECT
+			c.id,
+			c.is_group,
+			c.owner_id,
+			c.saved_for IS NOT NULL AS saved,
+			COALESCE(s.muted AND (s.muted_until IS NULL OR s.muted_until > now()), false) AS muted,
+			-- muted_until is local to the database, hand it out as an instant
+			CASE WHEN s.muted THEN s.muted_until AT TIME ZONE current_setting('TimeZone') END AS muted_until,
+			COALESCE(s.archived, false) AS archived,
+			s.pinned_at,
+			(SELECT MAX(created_at) FROM messages WHERE chat_id = c.id) AS last_message_at,
+			(SELECT COUNT(*) FROM messages msg
+			 WHERE msg.chat_id = c.id
+			 AND msg.id > me.last_read_message_id
+			 AND msg.sender_id != $1
+			 AND msg.sender_id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = $1)
+			) + (SELECT COUNT(*) FROM media_messages mm
+			 WHERE mm.chat_id = c.id
+			 AND mm.id > me.last_read_media_id
+			 AND mm.sender_id != $1
+			 AND mm.sender_id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = $1)
+			) AS unread
+		FROM chats c
+		JOIN chat_members me ON me.chat_id = c.id AND me.user_id = $1
+		LEFT JOIN chat_settings s ON s.chat_id = c.id AND s.user_id = $1
+	)`
+
+// folderRulesSQL matches a row of mine against folder f.
+const folderRulesSQL = `(
+		EXISTS (SELECT 1 FROM chat_folder_chats fc WHERE fc.folder_id = f.id AND fc.chat_id = mine.id)
+		OR (f.include_groups AND mine.is_group)
+		OR (f.include_direct AND NOT mine.is_group AND NOT mine.saved)
+	)
+	AND (NOT f.unread_only OR mine.unread > 0)
+	AND (NOT f.exclude_muted OR NOT mine.muted)`
+
+// GetChats lists the caller's chats: Saved Messages first, then pinned
+// ones, then by latest message. Archived chats are left out unless ?archived=true, which lists
+// only those. ?folder=<id> lists the chats in that folder instead,
+// archived or not.
 func GetChats(c *gin.Context) {
 	userID := c.GetString("user_id")
+	archived := c.Query("archived") == "true"
+
+	var folderID *int64
+	if f := c.Query("folder"); f != "" {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || !ownsFolder(userID, id) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+			return
+		}
+		folderID = &id
+	}
 
 	rows, err := db.DB.Query(`
+		WITH `+myChatsSQL+`
 		SELECT 
-			c.id,
-			c.is_group,
-			ARRAY_AGG(m.user_id) AS members
-		FROM chats c
-		JOIN chat_members m ON m.chat_id = c.id
-		WHERE c.id IN (
-			SELECT chat_id FROM chat_members WHERE user_id = $1
-		)
-		GROUP BY c.id, c.is_group
-	`, userID)
+			mine.id,
+			mine.is_group,
+			mine.owner_id,
+			mine.saved,
+			ARRAY_AGG(m.user_id) AS members,
+			ARRAY_AGG(u.username) AS usernames,
+			ARRAY_AGG(u.display_name) AS display_names,
+			mine.muted,
+			mine.muted_until,
+			mine.archived,
+			mine.pinned_at IS NOT NULL,
+			mine.last_message_at,
+			mine.unread
+		FROM mine
+		JOIN chat_members m ON m.chat_id = mine.id
+		JOIN users u ON u.id = m.user_id
+		WHERE CASE
+			WHEN $2::bigint IS NULL THEN mine.archived = $3
+			ELSE EXISTS (SELECT 1 FROM chat_folders f WHERE f.id = $2 AND `+folderRulesSQL+`)
+		END
+		GROUP BY mine.id, mine.is_group, mine.owner_id, mine.saved, mine.muted, mine.muted_until,
+			mine.archived, mine.pinned_at, mine.last_message_at, mine.unread
+		ORDER BY mine.saved DESC, mine.pinned_at ASC NULLS LAST, mine.last_message_at DESC NULLS LAST
+	`, userID, folderID, archived)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -117,20 +199,39 @@ func GetChats(c *gin.Context) {
 	}
 	defer rows.Close()
 
+	type Member struct {
+		ID          string `json:"id"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}
+
 	type ChatResponse struct {
-		ID      string   `json:"id"`
-		IsGroup bool     `json:"is_group"`
-		Members []string `json:"members"`
+		ID            string     `json:"id"`
+		IsGroup       bool       `json:"is_group"`
+		OwnerID       *string    `json:"owner_id"`
+		Saved         bool       `json:"saved"`
+		Members       []Member   `json:"members"`
+		Muted         bool       `json:"muted"`
+		MutedUntil    *time.Time `json:"muted_until"`
+		Archived      bool       `json:"archived"`
+		Pinned        bool       `json:"pinned"`
+		LastMessageAt *time.Time `json:"last_message_at"`
+		Unread        int        `json:"unread"`
 	}
 
 	var chats []ChatResponse
 
 	for rows.Next() {
 		var chat ChatResponse
-		if err := rows.Scan(&chat.ID, &chat.IsGroup, pq.Array(&chat.Members)); err != nil {
+		var ids, usernames, displayNames []string
+		if err := rows.Scan(&chat.ID, &chat.IsGroup, &chat.OwnerID, &chat.Saved, pq.Array(&ids), pq.Array(&usernames), pq.Array(&displayNames),
+			&chat.Muted, &chat.MutedUntil, &chat.Archived, &chat.Pinned, &chat.LastMessageAt, &chat.Unread); err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		for i := range ids {
+			chat.Members = append(chat.Members, Member{ID: ids[i], Username: usernames[i], DisplayName: displayNames[i]})
+		}
 		chats = append(chats, chat)
 	}
 
@@ -138,16 +239,41 @@ func GetChats(c *gin.Context) {
 }
 
 func AddMember(c *gin.Context) {
-	chatID := c.Param("id")
+	chatID := c.Param("chatId")
+	adder := c.GetString("user_id")
 
 	var req struct {
-		UserID string `json:"user_id"`
+		UserID   string `json:"user_id"`
+		Username string `json:"username"` // alternative to user_id
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
+	if req.Username != "" {
+		err := db.DB.QueryRow(
+			`SELECT id FROM users WHERE username = $1`,
+			req.Username,
+		).Scan(&req.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user does not exist: " + req.Username})
+			return
+		}
+	}
+
+	var saved bool
+	db.DB.QueryRow(`SELECT saved_for IS NOT NULL FROM chats WHERE id = $1`, chatID).Scan(&saved)
+	if saved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "saved messages is personal"})
+		return
+	}
+
+	if hasBlocked(req.UserID, adder) || !canAddToGroup(adder, req.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot add user"})
+		return
+	}
+
 	_, err := db.DB.Exec(
 		"INSERT INTO chat_members (chat_id, user_id) VALUES ($1, $2)",
 		chatID,
@@ -157,6 +283,7 @@ func AddMember(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "user already in chat"})
 		return
 	}
+	websocket.GlobalHub.InvalidateChat(chatID)
 
 	c.JSON(http.StatusOK, gin.H{"status": "member added"})
 }
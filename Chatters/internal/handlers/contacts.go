@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DirectoryEntry is a user as listed by search and the contacts list.
+type DirectoryEntry struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	IsContact   bool   `json:"is_contact"`
+}
+
+// SearchUsers matches ?q= against usernames and display names, prefix
+// matches first, then by trigram similarity. Users who turned off
+// discoverable only show up for their contacts and people they share a
+// chat with; users who blocked the caller never do.
+func SearchUsers(c *gin.Context) {
+	userID := c.GetString("user_id")
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+
+	if len([]rune(q)) < 2 {
+		c.JSON(400, gin.H{"error": "query too short"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	// escape LIKE wildcards typed by the user
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q) + "%"
+
+	rows, err := db.DB.Query(
+		`SELECT u.id, u.username, u.display_name, k.contact_id IS NOT NULL
+		 FROM users u
+		 LEFT JOIN contacts k ON k.user_id = $1 AND k.contact_id = u.id
+		 WHERE u.id != $1
+		 AND NOT EXISTS (
+		   SELECT 1 FROM blocks b WHERE b.blocker_id = u.id AND b.blocked_id = $1
+		 )
+		 AND (
+		   lower(u.username) LIKE $2 OR lower(u.display_name) LIKE $2
+		   OR lower(u.username) % $3 OR lower(u.display_name) % $3
+		 )
+		 AND (
+		   u.discoverable
+		   OR k.contact_id IS NOT NULL
+		   OR EXISTS (
+		     SELECT 1 FROM chat_members me
+		     JOIN chat_members them ON them.chat_id = me.chat_id
+		     WHERE me.user_id = $1 AND them.user_id = u.id
+		   )
+		 )
+		 ORDER BY
+		   (lower(u.username) LIKE $2 OR lower(u.display_name) LIKE $2) DESC,
+		   GREATEST(similarity(lower(u.username), $3), similarity(lower(u.display_name), $3)) DESC,
+		   u.username
+		 LIMIT $4`,
+		userID, prefix, q, limit,
+	)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	users := []DirectoryEntry{}
+	for rows.Next() {
+		var u DirectoryEntry
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.IsContact); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		users = append(users, u)
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func GetContacts(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	rows, err := db.DB.Query(
+		`SELECT u.id, u.username, u.display_name
+		 FROM contacts k
+		 JOIN users u ON u.id = k.contact_id
+		 WHERE k.user_id = $1
+		 ORDER BY lower(COALESCE(NULLIF(u.display_name, ''), u.username))`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	contacts := []DirectoryEntry{}
+	for rows.Next() {
+		u := DirectoryEntry{IsContact: true}
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		contacts = append(contacts, u)
+	}
+
+	c.JSON(http.StatusOK, contacts)
+}
+
+func AddContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"` // alternative to user_id
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.UserID == "") == (req.Username == "") {
+		c.JSON(400, gin.H{"error": "give either user_id or username"})
+		return
+	}
+
+	contactID, err := findUser(req.UserID, req.Username)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "user does not exist"})
+		return
+	}
+	if contactID == userID {
+		c.JSON(400, gin.H{"error": "cannot add yourself"})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`INSERT INTO contacts (user_id, contact_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		userID, contactID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "contact added", "user_id": contactID})
+}
+
+func RemoveContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	_, err := db.DB.Exec(
+		`DELETE FROM contacts WHERE user_id = $1 AND contact_id::text = $2`,
+		userID, c.Param("id"),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "contact removed"})
+}
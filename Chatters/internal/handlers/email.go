@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+	"messenger/internal/mail"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// appURL is the frontend origin users are sent back to.
+func appURL() string {
+	if base := os.Getenv("APP_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:3000"
+}
+
+// appLink builds a frontend URL carrying a mailed token.
+func appLink(path, token string) string {
+	return appURL() + path + "?token=" + url.QueryEscape(token)
+}
+
+func sendVerificationEmail(userID, username, email string) error {
+	token, err := auth.NewEmailToken(userID, auth.PurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Default.Send(mail.Message{
+		To:      email,
+		Subject: "Confirm your Chatters email",
+		Body: "Hi " + username + ",\n\n" +
+			"Confirm your email address by opening this link:\n" +
+			appLink("/verify-email", token) + "\n\n" +
+			"The link expires in 24 hours.\n",
+	})
+}
+
+func VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID, err := auth.ConsumeEmailToken(req.Token, auth.PurposeVerifyEmail)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`UPDATE users SET email_verified = true WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+func ResendVerification(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var username, email string
+	var verified bool
+	err := db.DB.QueryRow(
+		`SELECT username, email, email_verified FROM users WHERE id = $1`,
+		userID,
+	).Scan(&username, &email, &verified)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if verified {
+		c.JSON(400, gin.H{"error": "email already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(userID, username, email); err != nil {
+		c.JSON(500, gin.H{"error": "failed to send email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
+
+// ForgotPassword always answers the same way so it can't be used to find
+// out which emails are registered.
+func ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var userID, username string
+	err := db.DB.QueryRow(
+		`SELECT id, username FROM users WHERE email = $1`,
+		req.Email,
+	).Scan(&userID, &username)
+
+	if err == nil {
+		token, err := auth.NewEmailToken(userID, auth.PurposeResetPassword, resetPasswordTTL)
+		if err == nil {
+			err = mail.Default.Send(mail.Message{
+				To:      req.Email,
+				Subject: "Reset your Chatters password",
+				Body: "Hi " + username + ",\n\n" +
+					"Someone asked to reset your password. If it was you, open this link:\n" +
+					appLink("/reset-password", token) + "\n\n" +
+					"The link expires in one hour. If you didn't ask for it, ignore this email.\n",
+			})
+		}
+		if err != nil {
+			println("MAIL ERROR:", err.Error())
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the email is registered, a reset link was sent"})
+}
+
+// ResetPassword sets a new password from a mailed token and logs every
+// device out.
+func ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" || req.NewPassword == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID, err := auth.ConsumeEmailToken(req.Token, auth.PurposeResetPassword)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "password error"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE users SET password_hash = $1 WHERE id = $2`,
+		string(hash), userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to update password"})
+		return
+	}
+
+	// other reset links for the account are void now
+	_, err = tx.Exec(
+		`UPDATE email_tokens SET used_at = now()
+		 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, auth.PurposeResetPassword,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	_, err = tx.Exec(
+		`UPDATE sessions SET revoked_at = now()
+		 WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "commit failed"})
+		return
+	}
+	websocket.GlobalHub.DisconnectUser(userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset, please login again"})
+}
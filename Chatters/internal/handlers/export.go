@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ExportTTL is how long a finished archive can be downloaded.
+const ExportTTL = 7 * 24 * time.Hour
+
+// DownloadLinkTTL is how long one signed download link works.
+const DownloadLinkTTL = 15 * time.Minute
+
+const exportDir = "private_exports"
+
+// RequestDataExport queues an archive of everything we hold about the user.
+// Only one export runs at a time per user; one stuck for an hour (the
+// server restarted mid-way) is marked failed and no longer counts.
+func RequestDataExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	_, err := db.DB.Exec(
+		`UPDATE data_exports SET status = 'failed', completed_at = now()
+		 WHERE user_id = $1
+		 AND status IN ('pending', 'running')
+		 AND created_at <= now() - interval '1 hour'`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	// the partial unique index lets only one request through
+	var exportID string
+	err = db.DB.QueryRow(
+		`INSERT INTO data_exports (user_id) VALUES ($1)
+		 ON CONFLICT DO NOTHING
+		 RETURNING id`,
+		userID,
+	).Scan(&exportID)
+	if err == sql.ErrNoRows {
+		var status string
+		err = db.DB.QueryRow(
+			`SELECT id, status FROM data_exports
+			 WHERE user_id = $1 AND status IN ('pending', 'running')`,
+			userID,
+		).Scan(&exportID, &status)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"export_id": exportID, "status": status})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	go runDataExport(exportID, userID)
+
+	c.JSON(http.StatusAccepted, gin.H{"export_id": exportID, "status": "pending"})
+}
+
+func GetDataExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	exportID := c.Param("id")
+
+	var status string
+	var createdAt time.Time
+	var completedAt, expiresAt *time.Time
+	var secondsLeft *float64
+
+	err := db.DB.QueryRow(
+		`SELECT status, created_at, completed_at, expires_at,
+		        EXTRACT(EPOCH FROM expires_at - now())
+		 FROM data_exports
+		 WHERE id = $1 AND user_id = $2`,
+		exportID, userID,
+	).Scan(&status, &createdAt, &completedAt, &expiresAt, &secondsLeft)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "export not found"})
+		return
+	}
+
+	resp := gin.H{
+		"export_id":    exportID,
+		"status":       status,
+		"created_at":   createdAt,
+		"completed_at": completedAt,
+		"expires_at":   expiresAt,
+	}
+	if status == "ready" && secondsLeft != nil && *secondsLeft > 0 {
+		ttl := min(DownloadLinkTTL, time.Duration(*secondsLeft*float64(time.Second)))
+		resp["download_url"] = auth.SignPath(exportDownloadPath(exportID), ttl)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func exportDownloadPath(exportID string) string {
+	return "/api/account/exports/" + exportID + "/download"
+}
+
+// DownloadDataExport serves an archive to whoever holds a download_url
+// from GetDataExport, so it also works from a plain link.
+func DownloadDataExport(c *gin.Context) {
+	exportID := c.Param("id")
+
+	if !auth.VerifyPath(exportDownloadPath(exportID), c.Query("expires"), c.Query("sig")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired link"})
+		return
+	}
+
+	var path string
+	err := db.DB.QueryRow(
+		`SELECT file_path FROM data_exports
+		 WHERE id::text = $1
+		 AND status = 'ready'
+		 AND expires_at > now()`,
+		exportID,
+	).Scan(&path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "export not found or expired"})
+		return
+	}
+
+	c.FileAttachment(path, "chatters-export.zip")
+}
+
+// RunExportCleanup deletes archives past their expiry.
+func RunExportCleanup() {
+	for {
+		rows, err := db.DB.Query(
+			`UPDATE data_exports SET status = 'expired'
+			 WHERE status = 'ready' AND expires_at < now()
+			 RETURNING file_path`,
+		)
+		if err != nil {
+			println("EXPORT CLEANUP ERROR:", err.Error())
+		} else {
+			for rows.Next() {
+				var path string
+				if rows.Scan(&path) == nil {
+					os.Remove(path)
+				}
+			}
+			rows.Close()
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+func runDataExport(exportID, userID string) {
+	db.DB.Exec(`UPDATE data_exports SET status = 'running' WHERE id = $1`, exportID)
+
+	_ = os.MkdirAll(exportDir, 0700)
+	path := filepath.Join(exportDir, exportID+".zip")
+
+	if err := writeDataExport(path, userID); err != nil {
+		println("EXPORT ERROR:", exportID, err.Error())
+		os.Remove(path)
+		db.DB.Exec(
+			`UPDATE data_exports SET status = 'failed', completed_at = now() WHERE id = $1`,
+			exportID,
+		)
+		return
+	}
+
+	db.DB.Exec(
+		`UPDATE data_exports
+		 SET status = 'ready', file_path = $1, completed_at = now(),
+		     expires_at = now() + $2 * interval '1 second'
+		 WHERE id = $3`,
+		path, int(ExportTTL.Seconds()), exportID,
+	)
+}
+
+// writeDataExport zips up everything we hold about the user. A table that
+// keeps personal data gets a section here when it is added.
+func writeDataExport(path, userID string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	// 1️⃣ Profile
+	var profile struct {
+		ID            string     `json:"id"`
+		Username      string     `json:"username"`
+		DisplayName   string     `json:"display_name"`
+		Bio           string     `json:"bio"`
+		Email         string     `json:"email"`
+		EmailVerified bool       `json:"email_verified"`
+		TOTPEnabled   bool       `json:"totp_enabled"`
+		Status        Status     `json:"status"`
+		Avatar        string     `json:"avatar,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+		DeleteAfter   *time.Time `json:"delete_after"`
+	}
+	var avatarPath *string
+	err = db.DB.QueryRow(
+		`SELECT id, username, display_name, bio, email, email_verified, totp_enabled,
+		        status_text, status_emoji, status_expires_at, avatar_path,
+		        created_at, delete_after
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&profile.ID, &profile.Username, &profile.DisplayName, &profile.Bio,
+		&profile.Email, &profile.EmailVerified, &profile.TOTPEnabled,
+		&profile.Status.Text, &profile.Status.Emoji, &profile.Status.ExpiresAt,
+		&avatarPath, &profile.CreatedAt, &profile.DeleteAfter)
+	if err != nil {
+		return err
+	}
+	if avatarPath != nil {
+		profile.Avatar = "avatar" + filepath.Ext(*avatarPath)
+		if err := copyFileEntry(zw, profile.Avatar, *avatarPath); err != nil {
+			println("EXPORT ERROR:", err.Error())
+			profile.Avatar = ""
+		}
+	}
+	if err := writeJSONEntry(zw, "profile.json", profile); err != nil {
+		return err
+	}
+
+	// 2️⃣ Chat memberships
+	type Chat struct {
+		ID      string   `json:"id"`
+		IsGroup bool     `json:"is_group"`
+		IsOwner bool     `json:"is_owner"`
+		Members []string `json:"members"`
+	}
+	chats := []Chat{}
+	rows, err := db.DB.Query(
+		`SELECT c.id, c.is_group, COALESCE(c.owner_id = $1, false), ARRAY_AGG(u.username)
+		 FROM chats c
+		 JOIN chat_members m ON m.chat_id = c.id
+		 JOIN users u ON u.id = m.user_id
+		 WHERE c.id IN (SELECT chat_id FROM chat_members WHERE user_id = $1)
+		 GROUP BY c.id, c.is_group, c.owner_id`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var ch Chat
+		if err := rows.Scan(&ch.ID, &ch.IsGroup, &ch.IsOwner, pq.Array(&ch.Members)); err != nil {
+			rows.Close()
+			return err
+		}
+		chats = append(chats, ch)
+	}
+	rows.Close()
+	if err := writeJSONEntry(zw, "chats.json", chats); err != nil {
+		return err
+	}
+
+	// 3️⃣ Contacts
+	contacts := []DirectoryEntry{}
+	rows, err = db.DB.Query(
+		`SELECT u.id, u.username, u.display_name
+		 FROM contacts k
+		 JOIN users u ON u.id = k.contact_id
+		 WHERE k.user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		u := DirectoryEntry{IsContact: true}
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName); err != nil {
+			rows.Close()
+			return err
+		}
+		contacts = append(contacts, u)
+	}
+	rows.Close()
+	if err := writeJSONEntry(zw, "contacts.json", contacts); err != nil {
+		return err
+	}
+
+	// 4️⃣ Messages they sent
+	type Message struct {
+		ID        int       `json:"id"`
+		ChatID    string    `json:"chat_id"`
+		Content   string    `json:"content"`
+		Status    string    `json:"status"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	messages := []Message{}
+	rows, err = db.DB.Query(
+		`SELECT id, chat_id, COALESCE(content, ''), COALESCE(status, ''), created_at
+		 FROM messages
+		 WHERE sender_id = $1
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Content, &m.Status, &m.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := writeJSONEntry(zw, "messages.json", messages); err != nil {
+		return err
+	}
+
+	// 5️⃣ Uploaded media, listed and copied under media/
+	type Media struct {
+		ID        int       `json:"id"`
+		ChatID    string    `json:"chat_id"`
+		MimeType  string    `json:"mime_type"`
+		File      string    `json:"file"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	media := []Media{}
+	var paths []string
+	rows, err = db.DB.Query(
+		`SELECT id, chat_id, COALESCE(mime_type, ''), file_path, created_at
+		 FROM media_messages
+		 WHERE sender_id = $1
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var m Media
+		var p string
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.MimeType, &p, &m.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		m.File = fmt.Sprintf("media/%d_%s", m.ID, filepath.Base(p))
+		media = append(media, m)
+		paths = append(paths, p)
+	}
+	rows.Close()
+
+	for i, m := range media {
+		if err := copyFileEntry(zw, m.File, paths[i]); err != nil {
+			println("EXPORT ERROR:", err.Error())
+			media[i].File = "" // gone from disk, keep the metadata
+		}
+	}
+	if err := writeJSONEntry(zw, "media.json", media); err != nil {
+		return err
+	}
+
+	// 6️⃣ Everything else, shaped by the database
+	sections := []struct{ name, query string }{
+		{"settings.json", `
+			SELECT json_build_object(
+			  'discoverable', discoverable,
+			  'group_add', group_add,
+			  'last_seen_visibility', last_seen_visibility,
+			  'photo_visibility', photo_visibility,
+			  'read_receipts', read_receipts,
+			  'last_seen_at', last_seen_at)
+			FROM users WHERE id = $1`},
+		{"sessions.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT id, device_name, user_agent, ip, created_at, last_used_at, revoked_at
+			      FROM sessions WHERE user_id = $1) t`},
+		{"failed_logins.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT identifier, ip, user_agent, reason, created_at
+			      FROM failed_logins WHERE user_id = $1) t`},
+		{"passkeys.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT id, name, encode(credential_id, 'base64') AS credential_id,
+			             created_at, last_used_at
+			      FROM passkeys WHERE user_id = $1) t`},
+		{"identities.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT issuer, subject, created_at
+			      FROM user_identities WHERE user_id = $1) t`},
+		{"blocks.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT u.id, u.username, u.display_name, b.created_at
+			      FROM blocks b JOIN users u ON u.id = b.blocked_id
+			      WHERE b.blocker_id = $1) t`},
+		{"chat_settings.json", `
+			SELECT COALESCE(json_agg(t), '[]')
+			FROM (SELECT chat_id, muted, muted_until, archived, pinned_at
+			      FROM chat_settings WHERE user_id = $1) t`},
+		{"folders.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.position, t.id), '[]')
+			FROM (SELECT f.id, f.name, f.include_groups, f.include_direct,
+			             f.unread_only, f.exclude_muted, f.position, f.created_at,
+			             ARRAY(SELECT chat_id FROM chat_folder_chats WHERE folder_id = f.id) AS chats
+			      FROM chat_folders f WHERE f.user_id = $1) t`},
+		{"stars.json", `
+			SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+			FROM (SELECT chat_id, message_id, media_id, created_at
+			      FROM stars WHERE user_id = $1) t`},
+	}
+	for _, sec := range sections {
+		var data json.RawMessage
+		if err := db.DB.QueryRow(sec.query, userID).Scan(&data); err != nil {
+			return err
+		}
+		if err := writeJSONEntry(zw, sec.name, data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func copyFileEntry(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// maxFolders caps how many folders a user can have.
+const maxFolders = 10
+
+type Folder struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	ChatIDs       []string `json:"chat_ids"`
+	IncludeGroups bool     `json:"include_groups"`
+	IncludeDirect bool     `json:"include_direct"`
+	UnreadOnly    bool     `json:"unread_only"`
+	ExcludeMuted  bool     `json:"exclude_muted"`
+	Position      int      `json:"position"`
+	// unread messages and chats with unread messages in the folder
+	Unread      int `json:"unread"`
+	UnreadChats int `json:"unread_chats"`
+}
+
+type folderRequest struct {
+	Name          string   `json:"name"`
+	ChatIDs       []string `json:"chat_ids"`
+	IncludeGroups bool     `json:"include_groups"`
+	IncludeDirect bool     `json:"include_direct"`
+	UnreadOnly    bool     `json:"unread_only"`
+	ExcludeMuted  bool     `json:"exclude_muted"`
+	Position      int      `json:"position"`
+}
+
+func ownsFolder(userID string, folderID int64) bool {
+	var ok bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM chat_folders WHERE id = $1 AND user_id = $2
+		)`,
+		folderID, userID,
+	).Scan(&ok)
+	return ok
+}
+
+// notifyFolderUpdated lets the user's other devices refetch their folders.
+func notifyFolderUpdated(userID string, folderID int64, deleted bool) {
+	payload, _ := json.Marshal(gin.H{
+		"type":      "folder_updated",
+		"folder_id": folderID,
+		"deleted":   deleted,
+	})
+	websocket.GlobalHub.BroadcastToUsers([]string{userID}, payload)
+}
+
+// GetFolders lists the caller's folders with their unread totals.
+func GetFolders(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	rows, err := db.DB.Query(`
+		WITH `+myChatsSQL+`
+		SELECT
+			f.id, f.name,
+			ARRAY(SELECT chat_id::text FROM chat_folder_chats WHERE folder_id = f.id),
+			f.include_groups, f.include_direct, f.unread_only, f.exclude_muted, f.position,
+			COALESCE(SUM(mine.unread), 0),
+			COUNT(mine.id) FILTER (WHERE mine.unread > 0)
+		FROM chat_folders f
+		LEFT JOIN mine ON `+folderRulesSQL+`
+		WHERE f.user_id = $1
+		GROUP BY f.id
+		ORDER BY f.position, f.id
+	`, userID)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		var f Folder
+		if err := rows.Scan(&f.ID, &f.Name, pq.Array(&f.ChatIDs),
+			&f.IncludeGroups, &f.IncludeDirect, &f.UnreadOnly, &f.ExcludeMuted, &f.Position,
+			&f.Unread, &f.UnreadChats); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		folders = append(folders, f)
+	}
+
+	c.JSON(http.StatusOK, folders)
+}
+
+func bindFolder(c *gin.Context) (folderRequest, bool) {
+	var req folderRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return req, false
+	}
+	if utf8.RuneCountInString(req.Name) > 32 {
+		c.JSON(400, gin.H{"error": "folder name too long"})
+		return req, false
+	}
+	return req, true
+}
+
+// setFolderChats replaces the chats listed in the folder, keeping only the
+// ones the user is a member of.
+func setFolderChats(tx *sql.Tx, userID string, folderID int64, chatIDs []string) error {
+	_, err := tx.Exec(`DELETE FROM chat_folder_chats WHERE folder_id = $1`, folderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO chat_folder_chats (folder_id, chat_id)
+		 SELECT $1, chat_id FROM chat_members
+		 WHERE user_id = $2 AND chat_id::text = ANY($3)`,
+		folderID, userID, pq.Array(chatIDs),
+	)
+	return err
+}
+
+func CreateFolder(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	req, ok := bindFolder(c)
+	if !ok {
+		return
+	}
+
+	var count int
+	db.DB.QueryRow(`SELECT COUNT(*) FROM chat_folders WHERE user_id = $1`, userID).Scan(&count)
+	if count >= maxFolders {
+		c.JSON(400, gin.H{"error": "too many folders"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var folderID int64
+	err = tx.QueryRow(
+		`INSERT INTO chat_folders
+		   (user_id, name, include_groups, include_direct, unread_only, exclude_muted, position)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id`,
+		userID, req.Name, req.IncludeGroups, req.IncludeDirect, req.UnreadOnly, req.ExcludeMuted, req.Position,
+	).Scan(&folderID)
+	if err == nil {
+		err = setFolderChats(tx, userID, folderID, req.ChatIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	notifyFolderUpdated(userID, folderID, false)
+
+	c.JSON(http.StatusOK, gin.H{"folder_id": folderID})
+}
+
+// UpdateFolder replaces the folder's name and rules.
+func UpdateFolder(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || !ownsFolder(userID, folderID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+		return
+	}
+
+	req, ok := bindFolder(c)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE chat_folders
+		 SET name = $1, include_groups = $2, include_direct = $3,
+		     unread_only = $4, exclude_muted = $5, position = $6
+		 WHERE id = $7`,
+		req.Name, req.IncludeGroups, req.IncludeDirect, req.UnreadOnly, req.ExcludeMuted, req.Position, folderID,
+	)
+	if err == nil {
+		err = setFolderChats(tx, userID, folderID, req.ChatIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	notifyFolderUpdated(userID, folderID, false)
+
+	c.JSON(http.StatusOK, gin.H{"status": "folder updated"})
+}
+
+func DeleteFolder(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+		return
+	}
+
+	res, err := db.DB.Exec(
+		`DELETE FROM chat_folders WHERE id = $1 AND user_id = $2`,
+		folderID, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "folder not found"})
+		return
+	}
+
+	notifyFolderUpdated(userID, folderID, true)
+
+	c.JSON(http.StatusOK, gin.H{"status": "folder deleted"})
+}
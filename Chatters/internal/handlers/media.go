@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"messenger/internal/db"
@@ -38,29 +40,31 @@ func UploadMedia(c *gin.Context) {
 		return
 	}
 
-	dir := filepath.Join("private_uploads", chatID)
-	_ = os.MkdirAll(dir, 0700)
-
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	path := filepath.Join(dir, filename)
-
-	if err := c.SaveUploadedFile(file, path); err != nil {
+	path, err := saveUpload(c, file, chatID, file.Filename)
+	if err != nil {
 		c.JSON(500, gin.H{"error": "failed to save file"})
 		return
 	}
 
-	var mediaID int
-	var createdAt string
+	msg := websocket.ChatMessage{
+		ChatID:   chatID,
+		From:     userID,
+		Filename: file.Filename,
+	}
 
 	err = db.DB.QueryRow(
-		`INSERT INTO media_messages (chat_id, sender_id, file_path, mime_type)
-		 VALUES ($1, $2, $3, $4)
-		 RETURNING id, created_at`,
+		`WITH ins AS (
+		   INSERT INTO media_messages (chat_id, sender_id, file_path, mime_type)
+		   VALUES ($1, $2, $3, $4)
+		   RETURNING id, created_at, sender_id
+		 )
+		 SELECT ins.id, ins.created_at, u.username, u.display_name
+		 FROM ins JOIN users u ON u.id = ins.sender_id`,
 		chatID,
 		userID,
 		path,
 		file.Header.Get("Content-Type"),
-	).Scan(&mediaID, &createdAt)
+	).Scan(&msg.ID, &msg.CreatedAt, &msg.FromUsername, &msg.FromDisplayName)
 
 	if err != nil {
 		c.JSON(500, gin.H{"error": "db error"})
@@ -68,13 +72,29 @@ func UploadMedia(c *gin.Context) {
 	}
 
 	// 🔔 Broadcast media message WITH FULL DATA
-	websocket.GlobalHub.BroadcastMedia(
-		chatID,
-		mediaID,
-		file.Filename,
-		userID,
-		createdAt,
-	)
+	websocket.GlobalHub.BroadcastMedia(msg)
 
-	c.JSON(200, gin.H{"media_id": mediaID})
+	c.JSON(200, gin.H{"media_id": msg.ID})
+}
+
+// saveUpload stores an uploaded file as private_uploads/<dir>/<nanos>_<name>
+// and returns its path.
+func saveUpload(c *gin.Context, file *multipart.FileHeader, dir, name string) (string, error) {
+	dir = filepath.Join("private_uploads", dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(name)))
+	return path, c.SaveUploadedFile(file, path)
+}
+
+// mediaFilename recovers the uploaded name from a stored path,
+// private_uploads/<chat>/<nanos>_<name>.
+func mediaFilename(path string) string {
+	name := filepath.Base(path)
+	if _, orig, ok := strings.Cut(name, "_"); ok {
+		return orig
+	}
+	return name
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"messenger/internal/db"
@@ -10,10 +11,25 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// GetMessages returns the chat's messages. ?around=<message id> returns
+// only the 25 messages on either side of it instead.
 func GetMessages(c *gin.Context) {
 	chatID := c.Param("chatId")
 	userID := c.GetString("user_id")
 
+	var around *int32
+	if a := c.Query("around"); a != "" {
+		// message ids are int in the schema
+		n, err := strconv.ParseInt(a, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		id := int32(n)
+		around = &id
+	}
+	const aroundLimit = 25
+
 	var exists bool
 	db.DB.QueryRow(
 		`SELECT EXISTS (
@@ -28,49 +44,97 @@ func GetMessages(c *gin.Context) {
 		return
 	}
 
-	rows, _ := db.DB.Query(
-		`UPDATE messages
-		 SET status = 'seen'
-		 WHERE chat_id = $1
-		 AND sender_id != $2
-		 AND status != 'seen'
-		 RETURNING id`,
-		chatID, userID,
-	)
-
-	var seenIDs []int
-	for rows.Next() {
-		var id int
-		rows.Scan(&id)
-		seenIDs = append(seenIDs, id)
+	// 👁️ mark as read, and seen unless the reader turned read receipts off
+	if err := websocket.GlobalHub.MarkRead(chatID, userID); err != nil {
+		println("DB ERROR:", err.Error())
 	}
 
-	if len(seenIDs) > 0 {
-		websocket.GlobalHub.BroadcastSeen(chatID, seenIDs)
-	}
-
-	rows2, _ := db.DB.Query(
-		`SELECT id, sender_id, content, created_at, status
-		 FROM messages
-		 WHERE chat_id = $1
-		 ORDER BY created_at ASC`,
-		chatID,
+	rows2, err := db.DB.Query(
+		`SELECT m.id, COALESCE(m.sender_id::text, ''), COALESCE(u.username, ''),
+			COALESCE(u.display_name, ''), m.content, m.created_at, m.status,
+			m.forward_message_id, m.forward_chat_id, COALESCE(m.forward_sender_id::text, '')
+		 FROM messages m
+		 LEFT JOIN users u ON u.id = m.sender_id
+		 WHERE m.chat_id = $1
+		 AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE b.blocker_id = $2 AND b.blocked_id = m.sender_id
+		 )
+		 AND ($3::int IS NULL OR m.id IN (
+			(SELECT id FROM messages WHERE chat_id = $1 AND id <= $3 ORDER BY id DESC LIMIT $4)
+			UNION
+			(SELECT id FROM messages WHERE chat_id = $1 AND id > $3 ORDER BY id ASC LIMIT $4)
+		 ))
+		 ORDER BY m.created_at ASC`,
+		chatID, userID, around, aroundLimit,
 	)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows2.Close()
 
 	type Message struct {
-		ID        int       `json:"id"`
-		From      string    `json:"from"`
-		Content   string    `json:"content"`
-		CreatedAt time.Time `json:"created_at"`
-		Status    string    `json:"status"`
+		ID              int                `json:"id"`
+		From            string             `json:"from"`
+		FromUsername    string             `json:"from_username"`
+		FromDisplayName string             `json:"from_display_name"`
+		Content         string             `json:"content"`
+		CreatedAt       time.Time          `json:"created_at"`
+		Status          string             `json:"status"`
+		ForwardedFrom   *websocket.Forward `json:"forwarded_from,omitempty"`
 	}
 
 	var messages []Message
 	for rows2.Next() {
 		var m Message
-		rows2.Scan(&m.ID, &m.From, &m.Content, &m.CreatedAt, &m.Status)
+		var fwdID *int
+		var fwdChat *string
+		var fwdFrom string
+		rows2.Scan(&m.ID, &m.From, &m.FromUsername, &m.FromDisplayName, &m.Content, &m.CreatedAt, &m.Status,
+			&fwdID, &fwdChat, &fwdFrom)
+		if fwdID != nil && fwdChat != nil {
+			m.ForwardedFrom = &websocket.Forward{MessageID: *fwdID, ChatID: *fwdChat, From: fwdFrom}
+		}
 		messages = append(messages, m)
 	}
 
 	c.JSON(http.StatusOK, messages)
 }
+
+func SendMessage(c *gin.Context) {
+	chatID := c.Param("chatId")
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content required"})
+		return
+	}
+
+	var exists bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM chat_members
+			WHERE chat_id = $1 AND user_id = $2
+		)`,
+		chatID, userID,
+	).Scan(&exists)
+
+	if !exists {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a chat member"})
+		return
+	}
+
+	// same path as a message typed into the WebSocket
+	msg, err := websocket.GlobalHub.Send(c.Request.Context(), chatID, userID, req.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, msg)
+}
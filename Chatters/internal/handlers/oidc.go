@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// how long the user has to finish logging in at the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+var errOIDCDisabled = errors.New("single sign-on is not configured")
+
+// oidcClient is discovered from OIDC_ISSUER on first use, so the server
+// starts even while the identity provider is unreachable.
+type oidcClient struct {
+	issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *oidcClient
+)
+
+// getOIDC reads its settings from the environment:
+//
+//	OIDC_ISSUER          issuer URL, discovery is done from it
+//	OIDC_CLIENT_ID       client registered at the provider
+//	OIDC_CLIENT_SECRET   empty for public clients, PKCE is always used
+//	OIDC_REDIRECT_URL    this server's /oidc/callback as registered
+func getOIDC(c *gin.Context) (*oidcClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, errOIDCDisabled
+	}
+
+	client, err := newOIDCClient(c.Request.Context(), issuer,
+		os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))
+	if err != nil {
+		return nil, err
+	}
+	oidcCached = client
+	return oidcCached, nil
+}
+
+func newOIDCClient(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*oidcClient, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcClient{
+		issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// oidcIdentity is who the identity provider says logged in.
+type oidcIdentity struct {
+	Subject           string
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// identity redeems the authorization code and checks the ID token it
+// comes with against the provider's keys and the login's nonce.
+func (o *oidcClient) identity(ctx context.Context, code, verifier, nonce string) (oidcIdentity, error) {
+	var id oidcIdentity
+
+	token, err := o.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return id, errors.New("code exchange failed")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return id, errors.New("no id_token")
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		return id, errors.New("invalid id_token")
+	}
+
+	if err := idToken.Claims(&id); err != nil {
+		return id, errors.New("invalid claims")
+	}
+	id.Subject = idToken.Subject
+	return id, nil
+}
+
+// OIDCLogin sends the browser to the identity provider.
+func OIDCLogin(c *gin.Context) {
+	client, err := getOIDC(c)
+	if err == errOIDCDisabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "random error"})
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "random error"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// logins that were never finished
+	db.DB.Exec(`DELETE FROM oidc_logins WHERE expires_at < now()`)
+
+	_, err = db.DB.Exec(
+		`INSERT INTO oidc_logins (state, verifier, nonce, device_name, expires_at)
+		 VALUES ($1, $2, $3, $4, now() + $5 * interval '1 second')`,
+		state, verifier, nonce, c.Query("device_name"), int(oidcLoginTTL.Seconds()),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	// ties the callback to the browser that started the login
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("oidc_state", state, int(oidcLoginTTL.Seconds()), "/oidc", "", c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, client.config.AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	))
+}
+
+// OIDCCallback finishes the code flow, links or creates the user and hands
+// the tokens to the frontend in the URL fragment, which never reaches logs.
+func OIDCCallback(c *gin.Context) {
+	client, err := getOIDC(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := c.Query("error"); msg != "" {
+		oidcFail(c, msg)
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie("oidc_state")
+	if state == "" || cookie != state {
+		oidcFail(c, "state mismatch")
+		return
+	}
+	c.SetCookie("oidc_state", "", -1, "/oidc", "", c.Request.TLS != nil, true)
+
+	var verifier, nonce, deviceName string
+	err = db.DB.QueryRow(
+		`DELETE FROM oidc_logins
+		 WHERE state = $1 AND expires_at > now()
+		 RETURNING verifier, nonce, device_name`,
+		state,
+	).Scan(&verifier, &nonce, &deviceName)
+	if err != nil {
+		oidcFail(c, "login expired")
+		return
+	}
+
+	id, err := client.identity(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		oidcFail(c, err.Error())
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		oidcFail(c, "db error")
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := oidcUser(pgOIDCAccounts{tx}, client.issuer, id)
+	if err != nil {
+		oidcFail(c, err.Error())
+		return
+	}
+
+	// 🧱 same lockout as a password login, before anything is linked
+	if auth.LoginLocked(auth.AccountKey(userID), auth.IPKey(c.ClientIP())) > 0 {
+		oidcFail(c, "too many failed attempts, try again later")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		oidcFail(c, "db error")
+		return
+	}
+
+	// 🔐 2FA applies to SSO too, the frontend finishes with /login/2fa
+	var totpEnabled bool
+	if err := db.DB.QueryRow(`SELECT totp_enabled FROM users WHERE id = $1`, userID).Scan(&totpEnabled); err != nil {
+		oidcFail(c, "db error")
+		return
+	}
+	if totpEnabled {
+		challenge, err := auth.GenerateChallengeToken(userID, deviceName)
+		if err != nil {
+			oidcFail(c, "token error")
+			return
+		}
+		fragment := url.Values{}
+		fragment.Set("mfa_required", "true")
+		fragment.Set("challenge_token", challenge)
+		c.Redirect(http.StatusFound, appURL()+"/oidc/complete#"+fragment.Encode())
+		return
+	}
+
+	auth.ClearLoginFailures(userID)
+	tokens, err := newSession(c, userID, deviceName)
+	if err != nil {
+		oidcFail(c, "failed to create session")
+		return
+	}
+
+	fragment := url.Values{}
+	for k, v := range tokens {
+		fragment.Set(k, fmt.Sprint(v))
+	}
+	c.Redirect(http.StatusFound, appURL()+"/oidc/complete#"+fragment.Encode())
+}
+
+// oidcAccounts is the account storage oidcUser works on.
+type oidcAccounts interface {
+	// linkedUser returns sql.ErrNoRows for an identity seen for the first time
+	linkedUser(issuer, subject string) (string, error)
+	// userByEmail returns sql.ErrNoRows when nobody has the address, and
+	// whether we ever verified that it belongs to the account
+	userByEmail(email string) (userID string, verified bool, err error)
+	// createUser makes a password-less account, numbering the username
+	// if it's taken
+	createUser(username, email string, emailVerified bool) (string, error)
+	link(issuer, subject, userID string) error
+}
+
+// oidcUser returns the user linked to the identity. Unknown identities are
+// linked to the account with the same email when both we and the provider
+// verified it, or get a new account.
+func oidcUser(accounts oidcAccounts, issuer string, id oidcIdentity) (string, error) {
+	userID, err := accounts.linkedUser(issuer, id.Subject)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", errors.New("db error")
+	}
+
+	if id.Email == "" {
+		return "", errors.New("identity provider sent no email")
+	}
+
+	userID, verified, err := accounts.userByEmail(id.Email)
+	switch {
+	case err == nil && !id.EmailVerified:
+		// don't hand an existing account to whoever typed its email at the IdP
+		return "", errors.New("email not verified by identity provider")
+
+	case err == nil && !verified:
+		// nor the IdP user to whoever registered here with their email
+		return "", errors.New("an account with this email exists, verify its email before using SSO")
+
+	case err == sql.ErrNoRows:
+		username := id.PreferredUsername
+		if username == "" {
+			username = strings.SplitN(id.Email, "@", 2)[0]
+		}
+		userID, err = accounts.createUser(username, id.Email, id.EmailVerified)
+		if err != nil {
+			return "", errors.New("failed to create user")
+		}
+
+	case err != nil:
+		return "", errors.New("db error")
+	}
+
+	if err := accounts.link(issuer, id.Subject, userID); err != nil {
+		return "", errors.New("failed to link identity")
+	}
+	return userID, nil
+}
+
+// pgOIDCAccounts runs oidcUser inside one transaction.
+type pgOIDCAccounts struct {
+	tx *sql.Tx
+}
+
+func (a pgOIDCAccounts) linkedUser(issuer, subject string) (string, error) {
+	var userID string
+	err := a.tx.QueryRow(
+		`SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`,
+		issuer, subject,
+	).Scan(&userID)
+	return userID, err
+}
+
+func (a pgOIDCAccounts) userByEmail(email string) (string, bool, error) {
+	var userID string
+	var verified bool
+	err := a.tx.QueryRow(
+		`SELECT id, email_verified FROM users WHERE email = $1`,
+		email,
+	).Scan(&userID, &verified)
+	return userID, verified, err
+}
+
+func (a pgOIDCAccounts) createUser(username, email string, emailVerified bool) (string, error) {
+	username, err := freeUsername(a.tx, username)
+	if err != nil {
+		return "", err
+	}
+
+	// no password: the account can only log in through SSO until one is set
+	var userID string
+	err = a.tx.QueryRow(
+		`INSERT INTO users (username, email, password_hash, email_verified)
+		 VALUES ($1, $2, '', $3)
+		 RETURNING id`,
+		username, email, emailVerified,
+	).Scan(&userID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = createSavedChat(a.tx, userID)
+	return userID, err
+}
+
+func (a pgOIDCAccounts) link(issuer, subject, userID string) error {
+	_, err := a.tx.Exec(
+		`INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)`,
+		issuer, subject, userID,
+	)
+	return err
+}
+
+// freeUsername appends a number when the preferred username is taken.
+func freeUsername(tx *sql.Tx, base string) (string, error) {
+	username := base
+	for i := 2; ; i++ {
+		var taken bool
+		err := tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`,
+			username,
+		).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+		username = base + strconv.Itoa(i)
+	}
+}
+
+func oidcFail(c *gin.Context, reason string) {
+	c.Redirect(http.StatusFound, appURL()+"/oidc/complete#error="+url.QueryEscape(reason))
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
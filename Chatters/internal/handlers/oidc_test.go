@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID provider: discovery, keys and a token
+// endpoint that answers one authorization code.
+type mockProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	code     string
+	// claims of the ID token handed out for code
+	claims jwt.MapClaims
+	// signs with a key that isn't published when set
+	forgeWith *rsa.PrivateKey
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, clientID: "chatters", code: "good-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   b64(key.N.Bytes()),
+				"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != p.code || r.Form.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss": p.URL,
+			"aud": p.clientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signer := p.key
+		if p.forgeWith != nil {
+			signer = p.forgeWith
+		}
+		idToken, _ := token.SignedString(signer)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) client(t *testing.T) *oidcClient {
+	t.Helper()
+	client, err := newOIDCClient(context.Background(), p.URL, p.clientID, "", "http://localhost/oidc/callback")
+	if err != nil {
+		t.Fatalf("discovery: %v", err)
+	}
+	return client
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	p := newMockProvider(t)
+	p.claims = jwt.MapClaims{
+		"sub":                "sub-1",
+		"nonce":              "n1",
+		"email":              "ann@example.com",
+		"email_verified":     true,
+		"preferred_username": "ann",
+	}
+	client := p.client(t)
+	ctx := context.Background()
+
+	id, err := client.identity(ctx, "good-code", "verifier", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidcIdentity{Subject: "sub-1", Email: "ann@example.com", EmailVerified: true, PreferredUsername: "ann"}
+	if id != want {
+		t.Fatalf("identity = %+v", id)
+	}
+
+	if _, err := client.identity(ctx, "good-code", "verifier", "other-nonce"); err == nil {
+		t.Fatal("accepted an ID token for another login's nonce")
+	}
+	if _, err := client.identity(ctx, "bad-code", "verifier", "n1"); err == nil {
+		t.Fatal("accepted an unknown code")
+	}
+
+	p.forgeWith, _ = rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := client.identity(ctx, "good-code", "verifier", "n1"); err == nil {
+		t.Fatal("accepted an ID token signed with an unpublished key")
+	}
+}
+
+// memOIDCAccounts keeps oidcAccounts in maps.
+type memOIDCAccounts struct {
+	links    map[string]string // issuer + " " + subject -> user
+	emails   map[string]string // email -> user
+	verified map[string]bool   // user -> email verified
+	names    map[string]string // user -> username
+}
+
+func newMemOIDCAccounts() *memOIDCAccounts {
+	return &memOIDCAccounts{
+		links:    map[string]string{},
+		emails:   map[string]string{},
+		verified: map[string]bool{},
+		names:    map[string]string{},
+	}
+}
+
+func (a *memOIDCAccounts) linkedUser(issuer, subject string) (string, error) {
+	if id, ok := a.links[issuer+" "+subject]; ok {
+		return id, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+func (a *memOIDCAccounts) userByEmail(email string) (string, bool, error) {
+	if id, ok := a.emails[email]; ok {
+		return id, a.verified[id], nil
+	}
+	return "", false, sql.ErrNoRows
+}
+
+func (a *memOIDCAccounts) createUser(username, email string, emailVerified bool) (string, error) {
+	id := "user-" + strconv.Itoa(len(a.names)+1)
+	a.emails[email] = id
+	a.verified[id] = emailVerified
+	a.names[id] = username
+	return id, nil
+}
+
+func (a *memOIDCAccounts) link(issuer, subject, userID string) error {
+	a.links[issuer+" "+subject] = userID
+	return nil
+}
+
+func TestOIDCUserProvisionsNewAccounts(t *testing.T) {
+	accounts := newMemOIDCAccounts()
+
+	userID, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s1", Email: "ann@example.com", PreferredUsername: "annie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.names[userID] != "annie" {
+		t.Fatalf("username = %q", accounts.names[userID])
+	}
+
+	// no preferred username: the local part of the email
+	userID, err = oidcUser(accounts, "iss", oidcIdentity{Subject: "s2", Email: "bob@example.com"})
+	if err != nil || accounts.names[userID] != "bob" {
+		t.Fatalf("username = %q, %v", accounts.names[userID], err)
+	}
+
+	// the next login finds the link, even with another email
+	again, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s2", Email: "new@example.com"})
+	if err != nil || again != userID {
+		t.Fatalf("second login = %q, %v", again, err)
+	}
+
+	if _, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s3"}); err == nil {
+		t.Fatal("provisioned an account without an email")
+	}
+}
+
+func TestOIDCUserLinksOnlyVerifiedEmails(t *testing.T) {
+	accounts := newMemOIDCAccounts()
+	accounts.emails["ann@example.com"] = "existing"
+	accounts.verified["existing"] = true
+
+	if _, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s1", Email: "ann@example.com"}); err == nil {
+		t.Fatal("linked an existing account through an unverified email")
+	}
+	if _, ok := accounts.links["iss s1"]; ok {
+		t.Fatal("left a link behind")
+	}
+
+	userID, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s1", Email: "ann@example.com", EmailVerified: true})
+	if err != nil || userID != "existing" {
+		t.Fatalf("verified email = %q, %v", userID, err)
+	}
+	if accounts.links["iss s1"] != "existing" {
+		t.Fatal("identity not linked")
+	}
+}
+
+func TestOIDCUserSkipsAccountsWithUnverifiedEmails(t *testing.T) {
+	accounts := newMemOIDCAccounts()
+	// registered by someone who never proved they own the address
+	accounts.emails["ann@example.com"] = "squatter"
+
+	_, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s1", Email: "ann@example.com", EmailVerified: true})
+	if err == nil {
+		t.Fatal("linked an identity to an account whose email was never verified")
+	}
+	if _, ok := accounts.links["iss s1"]; ok {
+		t.Fatal("left a link behind")
+	}
+
+	accounts.verified["squatter"] = true
+	if userID, err := oidcUser(accounts, "iss", oidcIdentity{Subject: "s1", Email: "ann@example.com", EmailVerified: true}); err != nil || userID != "squatter" {
+		t.Fatalf("after verification = %q, %v", userID, err)
+	}
+}
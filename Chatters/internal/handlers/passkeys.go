@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const ceremonyTTL = 5 * time.Minute
+
+var (
+	webAuthnMu     sync.Mutex
+	webAuthnCached *webauthn.WebAuthn
+)
+
+// getWebAuthn reads the relying party from the environment:
+//
+//	WEBAUTHN_RP_ID        domain passkeys are bound to, e.g. chatters.app
+//	WEBAUTHN_RP_ORIGINS   comma separated origins allowed to use them,
+//	                      APP_URL by default
+func getWebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnMu.Lock()
+	defer webAuthnMu.Unlock()
+
+	if webAuthnCached != nil {
+		return webAuthnCached, nil
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	origins := strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",")
+	if origins[0] == "" {
+		origins = []string{appURL()}
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Chatters",
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	webAuthnCached = w
+	return w, nil
+}
+
+// passkeyUser adapts a Chatters user to webauthn.User. The user handle is
+// the 16 bytes of the immutable user id.
+type passkeyUser struct {
+	id          uuid.UUID
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return u.id[:] }
+func (u *passkeyUser) WebAuthnName() string                       { return u.username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func loadPasskeyUser(userID string) (*passkeyUser, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &passkeyUser{id: id}
+	err = db.DB.QueryRow(
+		`SELECT username FROM users WHERE id = $1`,
+		userID,
+	).Scan(&u.username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.Query(
+		`SELECT credential FROM passkeys WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw []byte
+		var cred webauthn.Credential
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &cred); err != nil {
+			return nil, err
+		}
+		u.credentials = append(u.credentials, cred)
+	}
+	return u, nil
+}
+
+func saveCeremony(userID string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.DB.Exec(
+		`INSERT INTO webauthn_ceremonies (id, user_id, session, expires_at)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, now() + $4 * interval '1 second')`,
+		id, userID, data, int(ceremonyTTL.Seconds()),
+	)
+	return id, err
+}
+
+// takeCeremony returns the session of a ceremony once, and only to the
+// user who started it (empty for logins).
+func takeCeremony(id, userID string) (webauthn.SessionData, error) {
+	var session webauthn.SessionData
+	var data []byte
+
+	err := db.DB.QueryRow(
+		`DELETE FROM webauthn_ceremonies
+		 WHERE id = $1
+		 AND COALESCE(user_id::text, '') = $2
+		 AND expires_at > now()
+		 RETURNING session`,
+		id, userID,
+	).Scan(&data)
+	if err != nil {
+		return session, errors.New("unknown or expired ceremony")
+	}
+
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
+
+func BeginPasskeyRegistration(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	w, err := getWebAuthn()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "webauthn misconfigured"})
+		return
+	}
+
+	user, err := loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	options, session, err := w.BeginRegistration(
+		user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to start registration"})
+		return
+	}
+
+	ceremonyID, err := saveCeremony(userID, session)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// FinishPasskeyRegistration takes the browser's credential as the body and
+// the ceremony id (and an optional passkey name) in the query.
+func FinishPasskeyRegistration(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	w, err := getWebAuthn()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "webauthn misconfigured"})
+		return
+	}
+
+	session, err := takeCeremony(c.Query("ceremony_id"), userID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	cred, err := w.FinishRegistration(user, session, c.Request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid credential"})
+		return
+	}
+
+	data, _ := json.Marshal(cred)
+	var id int64
+	err = db.DB.QueryRow(
+		`INSERT INTO passkeys (user_id, credential_id, name, credential)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id`,
+		userID, cred.ID, c.Query("name"), data,
+	).Scan(&id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "passkey already registered"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"passkey_id": id})
+}
+
+func GetPasskeys(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	rows, err := db.DB.Query(
+		`SELECT id, name, created_at, last_used_at
+		 FROM passkeys
+		 WHERE user_id = $1
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	type Passkey struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+	}
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var p Passkey
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		passkeys = append(passkeys, p)
+	}
+
+	c.JSON(http.StatusOK, passkeys)
+}
+
+func DeletePasskey(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	res, err := db.DB.Exec(
+		`DELETE FROM passkeys WHERE id = $1 AND user_id = $2`,
+		c.Param("id"), userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+}
+
+// BeginPasskeyLogin starts a usernameless login, the authenticator tells us
+// who the user is.
+func BeginPasskeyLogin(c *gin.Context) {
+	w, err := getWebAuthn()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "webauthn misconfigured"})
+		return
+	}
+
+	options, session, err := w.BeginDiscoverableLogin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to start login"})
+		return
+	}
+
+	ceremonyID, err := saveCeremony("", session)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+func FinishPasskeyLogin(c *gin.Context) {
+	w, err := getWebAuthn()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "webauthn misconfigured"})
+		return
+	}
+
+	session, err := takeCeremony(c.Query("ceremony_id"), "")
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	found, cred, err := w.FinishPasskeyLogin(
+		func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			return loadPasskeyUser(id.String())
+		},
+		session,
+		c.Request,
+	)
+	if err != nil || cred.Authenticator.CloneWarning {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid passkey"})
+		return
+	}
+	user := found.(*passkeyUser)
+
+	// keep the sign counter current for clone detection
+	data, _ := json.Marshal(cred)
+	_, _ = db.DB.Exec(
+		`UPDATE passkeys SET credential = $1, last_used_at = now()
+		 WHERE credential_id = $2`,
+		data, cred.ID,
+	)
+
+	issueTokens(c, user.id.String(), c.Query("device_name"))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BroadcastPresence tells the user's chat peers that they came online or
+// went offline, if the user's last-seen setting lets them know. Going
+// offline also records last_seen_at. Set as the hub's OnPresence.
+func BroadcastPresence(userID string, online bool) {
+	var audience string
+	var lastSeen time.Time
+
+	err := db.DB.QueryRow(
+		`UPDATE users
+		 SET last_seen_at = CASE WHEN $2 THEN last_seen_at ELSE now() END
+		 WHERE id = $1
+		 RETURNING last_seen_visibility, COALESCE(last_seen_at, now())`,
+		userID, online,
+	).Scan(&audience, &lastSeen)
+	if err != nil || audience == AudienceNobody {
+		return
+	}
+
+	peers, err := chatPeers(userID)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		return
+	}
+
+	var recipients []string
+	for _, p := range peers {
+		if audienceIncludes(audience, p.IsContact) {
+			recipients = append(recipients, p.ID)
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	event := gin.H{
+		"type":    "presence",
+		"user_id": userID,
+		"online":  online,
+	}
+	if !online {
+		event["last_seen"] = lastSeen
+	}
+
+	payload, _ := json.Marshal(event)
+	websocket.GlobalHub.BroadcastToUsers(recipients, payload)
+}
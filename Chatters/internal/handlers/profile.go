@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"unicode/utf8"
+
 	"messenger/internal/db"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 func ChangeUsername(c *gin.Context) {
 	current := c.GetString("user_id")
@@ -20,7 +24,7 @@ func ChangeUsername(c *gin.Context) {
 	// Check uniqueness
 	var exists bool
 	db.DB.QueryRow(
-		`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`,
 		req.NewUsername,
 	).Scan(&exists)
 
@@ -29,41 +33,28 @@ func ChangeUsername(c *gin.Context) {
 		return
 	}
 
-	tx, err := db.DB.Begin()
-	if err != nil {
-		c.JSON(500, gin.H{"error": "db error"})
-		return
-	}
-	defer tx.Rollback()
-
-	// Update users table
-	_, err = tx.Exec(
-		`UPDATE users SET id = $1 WHERE id = $2`,
-		req.NewUsername, current,
-	)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Update chat_members
-	_, err = tx.Exec(
-		`UPDATE chat_members SET user_id = $1 WHERE user_id = $2`,
+	// Everything else references the immutable id, tokens stay valid
+	_, err := db.DB.Exec(
+		`UPDATE users SET username = $1 WHERE id = $2`,
 		req.NewUsername, current,
 	)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		// a concurrent rename can still win the unique index
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(400, gin.H{"error": "username already taken"})
+			return
+		}
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
 
-	if err = tx.Commit(); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
+	go notifyProfileChange(current)
 
-	// Force re-login
 	c.JSON(200, gin.H{
-		"message": "username updated, please login again",
+		"message":  "username updated",
+		"username": req.NewUsername,
 	})
 }
 func ChangePassword(c *gin.Context) {
@@ -109,3 +100,101 @@ func ChangePassword(c *gin.Context) {
 
 	c.JSON(200, gin.H{"message": "password updated"})
 }
+
+// UpdateProfile changes the display name and/or bio; omitted fields are
+// left alone.
+func UpdateProfile(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.DisplayName != nil && utf8.RuneCountInString(*req.DisplayName) > 64 {
+		c.JSON(400, gin.H{"error": "display name too long"})
+		return
+	}
+	if req.Bio != nil && utf8.RuneCountInString(*req.Bio) > 500 {
+		c.JSON(400, gin.H{"error": "bio too long"})
+		return
+	}
+
+	_, err := db.DB.Exec(
+		`UPDATE users
+		 SET display_name = COALESCE($1, display_name),
+		     bio = COALESCE($2, bio)
+		 WHERE id = $3`,
+		req.DisplayName, req.Bio, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to update profile"})
+		return
+	}
+
+	go notifyProfileChange(userID)
+
+	p, _ := loadProfile(userID)
+	c.JSON(200, p)
+}
+
+// SetStatus sets a custom status, optionally cleared after expires_in
+// seconds.
+func SetStatus(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Text      string `json:"text"`
+		Emoji     string `json:"emoji"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.ExpiresIn < 0 {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > 100 || utf8.RuneCountInString(req.Emoji) > 16 {
+		c.JSON(400, gin.H{"error": "status too long"})
+		return
+	}
+
+	_, err := db.DB.Exec(
+		`UPDATE users
+		 SET status_text = $1, status_emoji = $2,
+		     status_expires_at = now() + NULLIF($3, 0) * interval '1 second'
+		 WHERE id = $4`,
+		req.Text, req.Emoji, req.ExpiresIn, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to update status"})
+		return
+	}
+
+	go notifyProfileChange(userID)
+
+	p, _ := loadProfile(userID)
+	c.JSON(200, p)
+}
+
+func ClearStatus(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	_, err := db.DB.Exec(
+		`UPDATE users
+		 SET status_text = '', status_emoji = '', status_expires_at = NULL
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to update status"})
+		return
+	}
+
+	go notifyProfileChange(userID)
+
+	c.JSON(200, gin.H{"message": "status cleared"})
+}
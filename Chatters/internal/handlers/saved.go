@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createSavedChat gives a new user their Saved Messages chat inside the
+// transaction that creates the account.
+func createSavedChat(tx *sql.Tx, userID string) (string, error) {
+	var chatID string
+	err := tx.QueryRow(
+		`INSERT INTO chats (is_group, saved_for)
+		 VALUES (false, $1)
+		 ON CONFLICT (saved_for) DO UPDATE SET saved_for = EXCLUDED.saved_for
+		 RETURNING id`,
+		userID,
+	).Scan(&chatID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO chat_members (chat_id, user_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT (chat_id, user_id) DO NOTHING`,
+		chatID, userID,
+	)
+	if err != nil {
+		return "", err
+	}
+	return chatID, nil
+}
+
+// savedChatID returns the user's Saved Messages chat. Accounts get one when
+// they're created; the fallback only covers rows that somehow lost it.
+func savedChatID(userID string) (string, error) {
+	var chatID string
+	err := db.DB.QueryRow(
+		`SELECT id FROM chats WHERE saved_for = $1`,
+		userID,
+	).Scan(&chatID)
+	if err != sql.ErrNoRows {
+		return chatID, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	chatID, err = createSavedChat(tx, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	websocket.GlobalHub.InvalidateChat(chatID)
+
+	return chatID, nil
+}
+
+func GetSavedChat(c *gin.Context) {
+	chatID, err := savedChatID(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"chat_id": chatID})
+}
+
+// isMember reports whether the user belongs to the chat.
+func isMember(chatID, userID string) bool {
+	var ok bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM chat_members
+			WHERE chat_id = $1 AND user_id = $2
+		)`,
+		chatID, userID,
+	).Scan(&ok)
+	return ok
+}
+
+// forwardTarget reads {"chat_id": ...} and defaults to Saved Messages.
+func forwardTarget(c *gin.Context, userID string) (string, bool) {
+	var req struct {
+		ChatID string `json:"chat_id"`
+	}
+	// an empty body means Saved Messages, anything else has to parse
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return "", false
+	}
+
+	if req.ChatID == "" {
+		chatID, err := savedChatID(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return "", false
+		}
+		return chatID, true
+	}
+
+	if !isMember(req.ChatID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a chat member"})
+		return "", false
+	}
+	return req.ChatID, true
+}
+
+// ForwardMessage copies a text message into another chat the caller is in,
+// Saved Messages when no chat_id is given.
+func ForwardMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var fwd websocket.Forward
+	var content string
+	var from sql.NullString
+	err := db.DB.QueryRow(
+		`SELECT id, chat_id, sender_id, content FROM messages WHERE id = $1`,
+		c.Param("id"),
+	).Scan(&fwd.MessageID, &fwd.ChatID, &from, &content)
+	if err != nil || !isMember(fwd.ChatID, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	fwd.From = from.String
+
+	chatID, ok := forwardTarget(c, userID)
+	if !ok {
+		return
+	}
+
+	msg, err := websocket.GlobalHub.SendForward(c.Request.Context(), chatID, userID, content, &fwd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, msg)
+}
+
+// ForwardMedia shares an uploaded file into another chat the caller is in,
+// Saved Messages when no chat_id is given. The file itself isn't copied.
+func ForwardMedia(c *gin.Context) {
+	userID := c.GetString("user_id")
+	mediaID := c.Param("id")
+
+	var srcChat, path string
+	var mime, from sql.NullString
+	err := db.DB.QueryRow(
+		`SELECT chat_id, file_path, mime_type, sender_id FROM media_messages WHERE id = $1`,
+		mediaID,
+	).Scan(&srcChat, &path, &mime, &from)
+	if err != nil || !isMember(srcChat, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	chatID, ok := forwardTarget(c, userID)
+	if !ok {
+		return
+	}
+
+	msg := websocket.ChatMessage{
+		ChatID:   chatID,
+		From:     userID,
+		Filename: mediaFilename(path),
+	}
+	err = db.DB.QueryRow(
+		`WITH ins AS (
+		   INSERT INTO media_messages (chat_id, sender_id, file_path, mime_type,
+		     forward_media_id, forward_chat_id, forward_sender_id)
+		   VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)
+		   RETURNING id, created_at, sender_id
+		 )
+		 SELECT ins.id, ins.created_at, u.username, u.display_name
+		 FROM ins JOIN users u ON u.id = ins.sender_id`,
+		chatID, userID, path, mime, mediaID, srcChat, from.String,
+	).Scan(&msg.ID, &msg.CreatedAt, &msg.FromUsername, &msg.FromDisplayName)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	websocket.GlobalHub.BroadcastMedia(msg)
+
+	c.JSON(http.StatusCreated, gin.H{"media_id": msg.ID, "chat_id": chatID})
+}
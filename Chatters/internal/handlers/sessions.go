@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	current := c.GetString("session_id")
+
+	rows, err := db.DB.Query(
+		`SELECT id, device_name, user_agent, ip, created_at, last_used_at
+		 FROM sessions
+		 WHERE user_id = $1
+		 AND revoked_at IS NULL
+		 AND last_used_at > now() - $2 * interval '1 second'
+		 ORDER BY last_used_at DESC`,
+		userID, int(auth.RefreshTokenTTL.Seconds()),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	type Session struct {
+		ID         string    `json:"id"`
+		DeviceName string    `json:"device_name"`
+		UserAgent  string    `json:"user_agent"`
+		IP         string    `json:"ip"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		Current    bool      `json:"current"`
+	}
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.DeviceName, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	res, err := db.DB.Exec(
+		`UPDATE sessions SET revoked_at = now()
+		 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		sessionID, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	websocket.GlobalHub.DisconnectSession(userID, sessionID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+func LogoutOtherSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	current := c.GetString("session_id")
+
+	if err := auth.RevokeOtherSessions(userID, current); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	websocket.GlobalHub.DisconnectOtherSessions(userID, current)
+
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions logged out"})
+}
+
+// CreateWSTicket hands out a one-time ticket for /api/ws so the JWT never
+// appears in a URL.
+func CreateWSTicket(c *gin.Context) {
+	ticket, err := auth.NewWSTicket(c.GetString("session_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(auth.WSTicketTTL.Seconds()),
+	})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audiences of a privacy setting.
+const (
+	AudienceEveryone = "everyone"
+	AudienceContacts = "contacts"
+	AudienceNobody   = "nobody"
+)
+
+type PrivacySettings struct {
+	Discoverable bool `json:"discoverable"`
+	// who may add me to groups
+	GroupAdd string `json:"group_add"`
+	// who sees when I was last online
+	LastSeen string `json:"last_seen"`
+	// who sees my avatar
+	ProfilePhoto string `json:"profile_photo"`
+	ReadReceipts bool   `json:"read_receipts"`
+}
+
+func loadPrivacySettings(userID string) (PrivacySettings, error) {
+	var s PrivacySettings
+	err := db.DB.QueryRow(
+		`SELECT discoverable, group_add, last_seen_visibility, photo_visibility, read_receipts
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&s.Discoverable, &s.GroupAdd, &s.LastSeen, &s.ProfilePhoto, &s.ReadReceipts)
+	return s, err
+}
+
+func validAudience(a *string) bool {
+	return a == nil || *a == AudienceEveryone || *a == AudienceContacts || *a == AudienceNobody
+}
+
+// isContact reports whether ownerID has contactID in their contacts.
+func isContact(ownerID, contactID string) bool {
+	var ok bool
+	db.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM contacts
+			WHERE user_id = $1 AND contact_id = $2
+		)`,
+		ownerID, contactID,
+	).Scan(&ok)
+	return ok
+}
+
+// allowedBy checks viewerID against an audience set by ownerID.
+func allowedBy(audience, ownerID, viewerID string) bool {
+	switch {
+	case ownerID == viewerID:
+		return true
+	case audience == AudienceEveryone:
+		return true
+	case audience == AudienceContacts:
+		return isContact(ownerID, viewerID)
+	}
+	return false
+}
+
+// canAddToGroup reports whether adderID may put userID into a group.
+func canAddToGroup(adderID, userID string) bool {
+	var audience string
+	err := db.DB.QueryRow(
+		`SELECT group_add FROM users WHERE id = $1`,
+		userID,
+	).Scan(&audience)
+	if err != nil {
+		return false
+	}
+	return allowedBy(audience, userID, adderID)
+}
+
+func GetPrivacySettings(c *gin.Context) {
+	s, err := loadPrivacySettings(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdatePrivacySettings changes the given settings; omitted fields are
+// left alone.
+func UpdatePrivacySettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Discoverable *bool   `json:"discoverable"`
+		GroupAdd     *string `json:"group_add"`
+		LastSeen     *string `json:"last_seen"`
+		ProfilePhoto *string `json:"profile_photo"`
+		ReadReceipts *bool   `json:"read_receipts"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+	if !validAudience(req.GroupAdd) || !validAudience(req.LastSeen) || !validAudience(req.ProfilePhoto) {
+		c.JSON(400, gin.H{"error": "audience must be everyone, contacts or nobody"})
+		return
+	}
+
+	_, err := db.DB.Exec(
+		`UPDATE users
+		 SET discoverable = COALESCE($1, discoverable),
+		     group_add = COALESCE($2, group_add),
+		     last_seen_visibility = COALESCE($3, last_seen_visibility),
+		     photo_visibility = COALESCE($4, photo_visibility),
+		     read_receipts = COALESCE($5, read_receipts)
+		 WHERE id = $6`,
+		req.Discoverable, req.GroupAdd, req.LastSeen, req.ProfilePhoto, req.ReadReceipts, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if req.ProfilePhoto != nil {
+		go notifyProfileChange(userID)
+	}
+
+	s, _ := loadPrivacySettings(userID)
+	c.JSON(http.StatusOK, s)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// star marks a row of table (messages or media_messages) for the caller,
+// who must be in its chat.
+func star(c *gin.Context, table, column string) {
+	userID := c.GetString("user_id")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var chatID string
+	err = db.DB.QueryRow(
+		`SELECT chat_id FROM `+table+` WHERE id = $1`,
+		id,
+	).Scan(&chatID)
+	if err != nil || !isMember(chatID, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`INSERT INTO stars (user_id, chat_id, `+column+`)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		userID, chatID, id,
+	)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "starred"})
+}
+
+func unstar(c *gin.Context, column string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid id"})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`DELETE FROM stars WHERE user_id = $1 AND `+column+` = $2`,
+		c.GetString("user_id"), id,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "unstarred"})
+}
+
+func StarMessage(c *gin.Context)   { star(c, "messages", "message_id") }
+func UnstarMessage(c *gin.Context) { unstar(c, "message_id") }
+func StarMedia(c *gin.Context)     { star(c, "media_messages", "media_id") }
+func UnstarMedia(c *gin.Context)   { unstar(c, "media_id") }
+
+// GetStarred lists the caller's stars across chats, newest first, hiding
+// blocked senders like GetMessages does. Pass the last star_id seen as
+// ?before= for the next page. context_url opens the chat around the item.
+func GetStarred(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	var before *int64
+	if b := c.Query("before"); b != "" {
+		id, err := strconv.ParseInt(b, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid cursor"})
+			return
+		}
+		before = &id
+	}
+
+	rows, err := db.DB.Query(
+		`SELECT s.id, s.created_at, s.chat_id,
+			COALESCE(s.message_id, s.media_id),
+			s.message_id IS NOT NULL,
+			COALESCE(m.sender_id, mm.sender_id)::text,
+			COALESCE(u.username, ''),
+			COALESCE(u.display_name, ''),
+			COALESCE(m.content, ''),
+			COALESCE(mm.file_path, ''),
+			COALESCE(mm.mime_type, ''),
+			COALESCE(m.created_at, mm.created_at),
+			-- media isn't in the message list, open it at the nearest message
+			COALESCE(s.message_id, (
+				SELECT n.id FROM messages n
+				WHERE n.chat_id = s.chat_id AND n.created_at <= mm.created_at
+				ORDER BY n.created_at DESC LIMIT 1
+			))
+		 FROM stars s
+		 LEFT JOIN messages m ON m.id = s.message_id
+		 LEFT JOIN media_messages mm ON mm.id = s.media_id
+		 LEFT JOIN users u ON u.id = COALESCE(m.sender_id, mm.sender_id)
+		 WHERE s.user_id = $1
+		 AND ($2::bigint IS NULL OR s.id < $2)
+		 AND NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE b.blocker_id = $1 AND b.blocked_id = COALESCE(m.sender_id, mm.sender_id)
+		 )
+		 ORDER BY s.id DESC
+		 LIMIT $3`,
+		userID, before, limit,
+	)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer rows.Close()
+
+	type Starred struct {
+		StarID          int64     `json:"star_id"`
+		StarredAt       time.Time `json:"starred_at"`
+		ChatID          string    `json:"chat_id"`
+		Kind            string    `json:"kind"` // message or media
+		ID              int       `json:"id"`
+		From            string    `json:"from"`
+		FromUsername    string    `json:"from_username"`
+		FromDisplayName string    `json:"from_display_name"`
+		Content         string    `json:"content,omitempty"`
+		Filename        string    `json:"filename,omitempty"`
+		MimeType        string    `json:"mime_type,omitempty"`
+		CreatedAt       time.Time `json:"created_at"`
+		ContextURL      string    `json:"context_url"`
+	}
+
+	items := []Starred{}
+	for rows.Next() {
+		var s Starred
+		var isMessage bool
+		var from *string
+		var path string
+		var around *int
+		if err := rows.Scan(&s.StarID, &s.StarredAt, &s.ChatID, &s.ID, &isMessage,
+			&from, &s.FromUsername, &s.FromDisplayName, &s.Content, &path, &s.MimeType,
+			&s.CreatedAt, &around); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+
+		s.Kind = "media"
+		if isMessage {
+			s.Kind = "message"
+		}
+		if from != nil {
+			s.From = *from
+		}
+		if path != "" {
+			s.Filename = mediaFilename(path)
+		}
+
+		s.ContextURL = "/api/chats/" + s.ChatID + "/messages"
+		if around != nil {
+			s.ContextURL += fmt.Sprintf("?around=%d", *around)
+		}
+		items = append(items, s)
+	}
+
+	resp := gin.H{"items": items}
+	if len(items) == limit {
+		resp["next_before"] = items[len(items)-1].StarID
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"messenger/internal/auth"
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollTOTP creates a pending TOTP secret. It only takes effect once a
+// code generated from it is confirmed.
+func EnrollTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var username string
+	var enabled bool
+	db.DB.QueryRow(
+		`SELECT username, totp_enabled FROM users WHERE id = $1`,
+		userID,
+	).Scan(&username, &enabled)
+
+	if enabled {
+		c.JSON(400, gin.H{"error": "2FA already enabled"})
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create secret"})
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2`,
+		secret, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(username, secret),
+	})
+}
+
+// ConfirmTOTP turns 2FA on and returns the recovery codes, which are only
+// ever shown here.
+func ConfirmTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	db.DB.QueryRow(
+		`SELECT totp_secret, totp_enabled FROM users WHERE id = $1`,
+		userID,
+	).Scan(&secret, &enabled)
+
+	if enabled || !secret.Valid {
+		c.JSON(400, gin.H{"error": "no pending 2FA enrollment"})
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, req.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create recovery codes"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE id = $2`,
+		step, userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec(
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hash,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "2FA enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP needs a current code (or recovery code) and the password.
+// Single sign-on accounts have no password; for them the code alone, or a
+// login made just now, is enough.
+func DisableTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// 🧱 a session is no licence to guess codes
+	if loginLocked(c, userID) {
+		return
+	}
+
+	var hash string
+	var freshSession bool
+	err := db.DB.QueryRow(
+		`SELECT u.password_hash,
+		   EXISTS (
+		     SELECT 1 FROM sessions s
+		     WHERE s.id::text = $2 AND s.created_at > now() - $3 * interval '1 second'
+		   )
+		 FROM users u WHERE u.id = $1`,
+		userID, c.GetString("session_id"), int(reauthWindow.Seconds()),
+	).Scan(&hash, &freshSession)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		auth.RecordLoginFailure(userID, userID, c.ClientIP(), c.Request.UserAgent(), "bad_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "incorrect password"})
+		return
+	}
+
+	switch {
+	case req.Code != "":
+		if !checkSecondFactor(userID, req.Code) {
+			auth.RecordLoginFailure(userID, userID, c.ClientIP(), c.Request.UserAgent(), "bad_2fa_code")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+	case hash == "" && freshSession:
+		// the single sign-on login just now already asked for a code
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "code required"})
+		return
+	}
+
+	auth.ClearLoginFailures(userID)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE users
+		 SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "2FA disabled"})
+}
+
+// LoginTOTP completes a login that Login answered with a challenge token.
+func LoginTOTP(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"` // TOTP or recovery code
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID, deviceName, err := auth.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+
+	if loginLocked(c, userID) {
+		return
+	}
+
+	if !checkSecondFactor(userID, req.Code) {
+		auth.RecordLoginFailure(userID, userID, c.ClientIP(), c.Request.UserAgent(), "bad_2fa_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	if err := auth.ConsumeChallengeToken(req.ChallengeToken); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+
+	auth.ClearLoginFailures(userID)
+	issueTokens(c, userID, deviceName)
+}
+
+// checkSecondFactor accepts a TOTP code that wasn't used before or an
+// unused recovery code, which is burnt.
+func checkSecondFactor(userID, code string) bool {
+	var secret sql.NullString
+	var lastStep int64
+	err := db.DB.QueryRow(
+		`SELECT totp_secret, totp_last_step
+		 FROM users
+		 WHERE id = $1 AND totp_enabled`,
+		userID,
+	).Scan(&secret, &lastStep)
+	if err != nil || !secret.Valid {
+		return false
+	}
+
+	if step, ok := auth.ValidateTOTP(secret.String, code, time.Now()); ok {
+		// the step check in the WHERE makes a replayed code lose the race
+		res, err := db.DB.Exec(
+			`UPDATE users SET totp_last_step = $1
+			 WHERE id = $2 AND totp_last_step < $1`,
+			step, userID,
+		)
+		if err != nil {
+			return false
+		}
+		n, _ := res.RowsAffected()
+		return n == 1
+	}
+
+	res, err := db.DB.Exec(
+		`UPDATE recovery_codes SET used_at = now()
+		 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, auth.HashRecoveryCode(code),
+	)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
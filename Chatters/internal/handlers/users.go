@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"messenger/internal/db"
+	"messenger/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Status struct {
+	Text      string     `json:"text"`
+	Emoji     string     `json:"emoji"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type Profile struct {
+	ID             string     `json:"id"`
+	Username       string     `json:"username"`
+	DisplayName    string     `json:"display_name"`
+	Bio            string     `json:"bio"`
+	AvatarURL      string     `json:"avatar_url,omitempty"`
+	AvatarThumbURL string     `json:"avatar_thumb_url,omitempty"`
+	Status         *Status    `json:"status"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+
+	photoVisibility    string
+	lastSeenVisibility string
+}
+
+// loadProfile reads the full profile of a user. An expired status is
+// reported as no status. Use viewedBy before handing it to anyone else.
+func loadProfile(userID string) (Profile, error) {
+	var p Profile
+	var hasAvatar bool
+	var s Status
+	var expired bool
+
+	err := db.DB.QueryRow(
+		`SELECT id, username, display_name, bio, avatar_path IS NOT NULL,
+		        status_text, status_emoji, status_expires_at,
+		        COALESCE(status_expires_at <= now(), false),
+		        last_seen_at, photo_visibility, last_seen_visibility
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&p.ID, &p.Username, &p.DisplayName, &p.Bio, &hasAvatar,
+		&s.Text, &s.Emoji, &s.ExpiresAt, &expired,
+		&p.LastSeen, &p.photoVisibility, &p.lastSeenVisibility)
+	if err != nil {
+		return p, err
+	}
+
+	if hasAvatar {
+		p.AvatarURL = "/api/users/" + p.ID + "/avatar"
+		p.AvatarThumbURL = p.AvatarURL + "?size=thumb"
+	}
+
+	if (s.Text != "" || s.Emoji != "") && !expired {
+		p.Status = &s
+	}
+	return p, nil
+}
+
+// viewedBy strips what the owner's privacy settings hide from the viewer.
+func (p Profile) viewedBy(isSelf, isContact bool) Profile {
+	if isSelf {
+		return p
+	}
+	if !audienceIncludes(p.photoVisibility, isContact) {
+		p.AvatarURL, p.AvatarThumbURL = "", ""
+	}
+	if !audienceIncludes(p.lastSeenVisibility, isContact) {
+		p.LastSeen = nil
+	}
+	return p
+}
+
+// withoutPresence strips what a block keeps from the other side: status,
+// last seen and photo.
+func (p Profile) withoutPresence() Profile {
+	p.AvatarURL, p.AvatarThumbURL = "", ""
+	p.Status = nil
+	p.LastSeen = nil
+	return p
+}
+
+func audienceIncludes(audience string, isContact bool) bool {
+	return audience == AudienceEveryone || (audience == AudienceContacts && isContact)
+}
+
+func GetUserProfile(c *gin.Context) {
+	viewerID := c.GetString("user_id")
+
+	p, err := loadProfile(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	isSelf := p.ID == viewerID
+	p = p.viewedBy(isSelf, isContact(p.ID, viewerID))
+
+	// 🚫 presence is not exchanged across a block, whoever set it
+	if !isSelf && blockedEitherWay(p.ID, viewerID) {
+		p = p.withoutPresence()
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// findUser resolves a request that names a user by id or by username.
+// Exactly one of the two has to be given.
+func findUser(id, username string) (string, error) {
+	var userID string
+	var err error
+	switch {
+	case id != "" && username == "":
+		err = db.DB.QueryRow(`SELECT id FROM users WHERE id::text = $1`, id).Scan(&userID)
+	case username != "" && id == "":
+		err = db.DB.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&userID)
+	default:
+		err = errors.New("give either user_id or username")
+	}
+	return userID, err
+}
+
+// peer is someone who shares a chat with a user.
+type peer struct {
+	ID string
+	// the user has them in their contacts
+	IsContact bool
+}
+
+// chatPeers lists everyone the user shares a chat with, leaving out anyone
+// on either side of a block.
+func chatPeers(userID string) ([]peer, error) {
+	rows, err := db.DB.Query(
+		`SELECT DISTINCT other.user_id,
+		        EXISTS (SELECT 1 FROM contacts k WHERE k.user_id = $1 AND k.contact_id = other.user_id)
+		 FROM chat_members me
+		 JOIN chat_members other ON other.chat_id = me.chat_id
+		 WHERE me.user_id = $1
+		 AND other.user_id != $1
+		 AND NOT EXISTS (
+		   SELECT 1 FROM blocks b
+		   WHERE (b.blocker_id = $1 AND b.blocked_id = other.user_id)
+		   OR (b.blocker_id = other.user_id AND b.blocked_id = $1)
+		 )`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []peer
+	for rows.Next() {
+		var p peer
+		if err := rows.Scan(&p.ID, &p.IsContact); err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// notifyProfileChange pushes the user's new profile to their own devices
+// and everyone they share a chat with, as each of them is allowed to see it.
+func notifyProfileChange(userID string) {
+	p, err := loadProfile(userID)
+	if err != nil {
+		return
+	}
+
+	peers, err := chatPeers(userID)
+	if err != nil {
+		println("DB ERROR:", err.Error())
+		return
+	}
+
+	// the visible profile only depends on being a contact or not
+	var contacts, others []string
+	for _, peer := range peers {
+		if peer.IsContact {
+			contacts = append(contacts, peer.ID)
+		} else {
+			others = append(others, peer.ID)
+		}
+	}
+
+	send := func(userIDs []string, p Profile) {
+		if len(userIDs) == 0 {
+			return
+		}
+		payload, _ := json.Marshal(gin.H{
+			"type":    "profile",
+			"profile": p,
+		})
+		websocket.GlobalHub.BroadcastToUsers(userIDs, payload)
+	}
+
+	send([]string{userID}, p)
+	send(contacts, p.viewedBy(false, true))
+	send(others, p.viewedBy(false, false))
+}
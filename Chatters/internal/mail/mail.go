@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is what handlers send through, replaced by FromEnv at startup.
+var Default Mailer = Log{}
+
+// FromEnv picks the mailer from the environment:
+//
+//	MAIL_SMTP_ADDR   host:port of an SMTP server (a local fake one works)
+//	MAIL_SMTP_USER   optional, enables PLAIN auth with MAIL_SMTP_PASSWORD
+//	MAIL_FROM        sender address
+//	MAIL_SINK_FILE   without SMTP, append mails to this file instead of the log
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@chatters.app"
+	}
+
+	if addr := os.Getenv("MAIL_SMTP_ADDR"); addr != "" {
+		return &SMTP{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("MAIL_SMTP_USER"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+		}
+	}
+	if path := os.Getenv("MAIL_SINK_FILE"); path != "" {
+		return &File{Path: path}
+	}
+	return Log{}
+}
+
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, format(s.From, msg))
+}
+
+// File appends every mail to a file, handy for local development and for
+// reading tokens back in scripts.
+type File struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (f *File) Send(msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = fmt.Fprintf(out, "%s\n", format("", msg))
+	return err
+}
+
+// Log prints mails to the server log.
+type Log struct{}
+
+func (Log) Send(msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"messenger/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminOnly must run after AuthMiddleware.
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var isAdmin bool
+		db.DB.QueryRow(
+			`SELECT is_admin FROM users WHERE id = $1`,
+			c.GetString("user_id"),
+		).Scan(&isAdmin)
+
+		if !isAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -31,9 +31,18 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("user_id", userID)
+		// 🚫 session revoked (logout, signed out from another device, refresh
+		// token reuse, password reset)
+		sessionID, _ := claims["sid"].(string)
+		if sessionID == "" || !auth.SessionActive(sessionID, userID) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			return
+		}
 
-		c.Set("user_id", claims["user_id"])
+		go auth.TouchSession(sessionID)
+
+		c.Set("user_id", userID)
+		c.Set("session_id", sessionID)
 
 		c.Next()
 	}
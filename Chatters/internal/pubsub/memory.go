@@ -0,0 +1,75 @@
+package pubsub
+
+import "sync"
+
+// Memory is a process-local backplane. Hubs sharing one Memory behave like
+// separate instances connected through Postgres.
+//
+// Publish never waits for a subscriber: each one has an unbounded queue
+// drained by its own goroutine, so a hub that publishes from the same loop
+// that consumes its subscription can't deadlock. Every subscriber sees
+// payloads in the same order.
+type Memory struct {
+	mu   sync.Mutex
+	subs []*memorySub
+}
+
+type memorySub struct {
+	mu      sync.Mutex
+	pending [][]byte
+	wake    chan struct{}
+	out     chan []byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// queueing never blocks, holding the lock keeps one order for everyone
+	for _, sub := range m.subs {
+		sub.push(payload)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe() (<-chan []byte, error) {
+	sub := &memorySub{
+		wake: make(chan struct{}, 1),
+		out:  make(chan []byte, 256),
+	}
+	go sub.drain()
+
+	m.mu.Lock()
+	m.subs = append(m.subs, sub)
+	m.mu.Unlock()
+
+	return sub.out, nil
+}
+
+func (s *memorySub) push(payload []byte) {
+	s.mu.Lock()
+	s.pending = append(s.pending, payload)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySub) drain() {
+	for range s.wake {
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+
+		for _, payload := range batch {
+			s.out <- payload
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryFanOutInOrder(t *testing.T) {
+	m := NewMemory()
+	a, _ := m.Subscribe()
+	b, _ := m.Subscribe()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		m.Publish([]byte(strconv.Itoa(i)))
+	}
+
+	for name, sub := range map[string]<-chan []byte{"a": a, "b": b} {
+		for i := 0; i < n; i++ {
+			select {
+			case got := <-sub:
+				if string(got) != strconv.Itoa(i) {
+					t.Fatalf("%s: payload %d = %q", name, i, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: payload %d never arrived", name, i)
+			}
+		}
+	}
+}
+
+func TestMemoryPublishDoesNotWaitForSubscribers(t *testing.T) {
+	m := NewMemory()
+	m.Subscribe() // never read
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			m.Publish([]byte("x"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that isn't reading")
+	}
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	channel = "hub_events"
+	// NOTIFY payloads must stay below 8000 bytes, bigger events are
+	// parked in the hub_events table and only their id is sent
+	maxNotifyPayload = 7900
+	// how long parked events are kept for slow listeners
+	eventRetention = time.Minute
+)
+
+// Postgres fans events out to every instance with LISTEN/NOTIFY.
+type Postgres struct {
+	db  *sql.DB
+	dsn string
+}
+
+func NewPostgres(db *sql.DB, dsn string) *Postgres {
+	p := &Postgres{db: db, dsn: dsn}
+	go p.cleanup()
+	return p
+}
+
+func (p *Postgres) Publish(payload []byte) error {
+	msg := string(payload)
+
+	if len(payload) > maxNotifyPayload {
+		var id int64
+		err := p.db.QueryRow(
+			`INSERT INTO hub_events (payload) VALUES ($1) RETURNING id`,
+			msg,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		msg = "@" + strconv.FormatInt(id, 10)
+	}
+
+	_, err := p.db.Exec(`SELECT pg_notify($1, $2)`, channel, msg)
+	return err
+}
+
+func (p *Postgres) Subscribe() (<-chan []byte, error) {
+	listener := pq.NewListener(p.dsn, time.Second, time.Minute, nil)
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	out := make(chan []byte, 256)
+	go func() {
+		for n := range listener.Notify {
+			// nil after a reconnect, anything sent meanwhile is gone
+			if n == nil {
+				out <- nil
+				continue
+			}
+
+			if !strings.HasPrefix(n.Extra, "@") {
+				out <- []byte(n.Extra)
+				continue
+			}
+
+			var payload string
+			err := p.db.QueryRow(
+				`SELECT payload FROM hub_events WHERE id = $1`,
+				strings.TrimPrefix(n.Extra, "@"),
+			).Scan(&payload)
+			if err != nil {
+				continue
+			}
+			out <- []byte(payload)
+		}
+	}()
+
+	return out, nil
+}
+
+func (p *Postgres) cleanup() {
+	for range time.Tick(eventRetention) {
+		_, _ = p.db.Exec(
+			`DELETE FROM hub_events WHERE created_at < now() - $1 * interval '1 second'`,
+			eventRetention.Seconds(),
+		)
+	}
+}
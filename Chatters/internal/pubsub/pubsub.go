@@ -0,0 +1,13 @@
+package pubsub
+
+// Backplane carries hub events between server instances. Every instance
+// publishes what it produces and delivers whatever it receives, including
+// its own events, to the clients connected to it.
+type Backplane interface {
+	Publish(payload []byte) error
+
+	// Subscribe returns the stream of published payloads. A nil payload
+	// means the backplane lost its connection and events may have been
+	// missed, so local caches should be dropped.
+	Subscribe() (<-chan []byte, error)
+}
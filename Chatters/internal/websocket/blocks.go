@@ -0,0 +1,65 @@
+package websocket
+
+import "sync"
+
+// blockCache maps a user to the users who blocked them, so frames they
+// send can be withheld from those users without a query per frame.
+// Anything that writes blocks must invalidate the blocked user through
+// the hub.
+type blockCache struct {
+	store Store
+
+	mu       sync.RWMutex
+	blockers map[string]map[string]bool
+	// same role as memberCache.version
+	version uint64
+}
+
+func newBlockCache(store Store) *blockCache {
+	return &blockCache{
+		store:    store,
+		blockers: make(map[string]map[string]bool),
+	}
+}
+
+func (b *blockCache) get(userID string) (map[string]bool, error) {
+	b.mu.RLock()
+	blockers, ok := b.blockers[userID]
+	version := b.version
+	b.mu.RUnlock()
+
+	if ok {
+		return blockers, nil
+	}
+
+	ids, err := b.store.BlockedBy(userID)
+	if err != nil {
+		return nil, err
+	}
+	blockers = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		blockers[id] = true
+	}
+
+	b.mu.Lock()
+	if b.version == version {
+		b.blockers[userID] = blockers
+	}
+	b.mu.Unlock()
+
+	return blockers, nil
+}
+
+func (b *blockCache) invalidate(userID string) {
+	b.mu.Lock()
+	delete(b.blockers, userID)
+	b.version++
+	b.mu.Unlock()
+}
+
+func (b *blockCache) invalidateAll() {
+	b.mu.Lock()
+	b.blockers = make(map[string]map[string]bool)
+	b.version++
+	b.mu.Unlock()
+}
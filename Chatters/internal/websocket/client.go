@@ -2,14 +2,14 @@ package websocket
 
 import (
 	"github.com/gorilla/websocket"
-	"messenger/internal/db"
 	"encoding/json"
 )
 
 type Client struct {
-	UserID string
-	Conn   *websocket.Conn
-	Send   chan []byte
+	UserID    string
+	SessionID string
+	Conn      *websocket.Conn // nil for SSE and long-poll clients
+	Send      chan []byte
 }
 
 func readPump(hub *Hub, client *Client) {
@@ -31,25 +31,8 @@ func readPump(hub *Hub, client *Client) {
 
 		// 🔴 HANDLE "SEEN" EVENT HERE
 		if msg.Type == "seen" {
-			rows, _ := db.DB.Query(
-				`UPDATE messages
-				 SET status = 'seen'
-				 WHERE chat_id = $1
-				 AND sender_id != $2
-				 AND status != 'seen'
-				 RETURNING id`,
-				msg.ChatID, client.UserID,
-			)
-
-			var seenIDs []int
-			for rows.Next() {
-				var id int
-				rows.Scan(&id)
-				seenIDs = append(seenIDs, id)
-			}
-
-			if len(seenIDs) > 0 {
-				hub.BroadcastSeen(msg.ChatID, seenIDs)
+			if err := hub.MarkRead(msg.ChatID, client.UserID); err != nil {
+				println("DB ERROR:", err.Error())
 			}
 
 			continue // ⬅️ IMPORTANT: do NOT treat as chat message
@@ -57,6 +40,7 @@ func readPump(hub *Hub, client *Client) {
 
 		// 🟢 NORMAL CHAT MESSAGE
 		msg.From = client.UserID
+		msg.Forward = nil // forwards go through the REST endpoint
 		hub.Incoming <- msg
 	}
 }
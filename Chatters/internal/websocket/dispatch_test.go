@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"messenger/internal/pubsub"
+)
+
+func TestBlockersDontGetMessages(t *testing.T) {
+	store := newMemStore()
+	store.setMembers("c1", "alice", "bob", "carol")
+	store.blocked["alice"] = []string{"bob"}
+	h := startHub(t, store, pubsub.NewMemory())
+
+	bob := connect(h, "bob")
+	carol := connect(h, "carol")
+
+	send(t, h, "c1", "alice", "hi")
+	next(t, carol)
+	nothing(t, bob)
+
+	// unblocking takes effect once the hub is told
+	store.mu.Lock()
+	delete(store.blocked, "alice")
+	store.mu.Unlock()
+	h.InvalidateBlocks("alice")
+
+	send(t, h, "c1", "alice", "again")
+	next(t, carol)
+	if frame := next(t, bob); frame["content"] != "again" {
+		t.Fatalf("bob got %v", frame)
+	}
+}
+
+func TestMutedMembersGetSilentMessages(t *testing.T) {
+	store := newMemStore()
+	store.setMembers("c1", "alice", "bob", "carol")
+	store.mutes["c1"] = map[string]time.Time{
+		"bob":   {},                           // until unmuted
+		"carol": time.Now().Add(-time.Minute), // already over
+	}
+	h := startHub(t, store, pubsub.NewMemory())
+
+	bob := connect(h, "bob")
+	carol := connect(h, "carol")
+
+	send(t, h, "c1", "alice", "hi")
+	if frame := next(t, bob); frame["silent"] != true {
+		t.Fatalf("bob got %v", frame)
+	}
+	if frame := next(t, carol); frame["silent"] != nil {
+		t.Fatalf("carol got %v", frame)
+	}
+
+	// receipts aren't new content, nobody gets them silently
+	h.BroadcastSeen("c1", "carol", []int{1})
+	if frame := next(t, bob); frame["type"] != "seen" || frame["silent"] != nil {
+		t.Fatalf("bob got %v", frame)
+	}
+}
+
+func TestSendGivesUpWithTheContext(t *testing.T) {
+	h := NewHubWithStore(newMemStore(), pubsub.NewMemory(), 1)
+	// no Run, nothing ever picks the job up
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := h.Send(ctx, "c1", "alice", "hi"); err != context.DeadlineExceeded {
+		t.Fatalf("Send = %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package websocket
 
 import (
+	"encoding/json"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"messenger/internal/auth" // 🔑 use auth package directly
 
@@ -9,34 +13,51 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// authTimeout is how long a connection opened without a ticket has to send
+// its auth frame.
+const authTimeout = 10 * time.Second
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // dev only
-	},
+	CheckOrigin: checkOrigin,
 }
 
-func HandleWebSocket(hub *Hub) gin.HandlerFunc {
-	return func(c *gin.Context) {
+// allowedOrigins comes from WS_ALLOWED_ORIGINS (comma separated), the dev
+// frontend by default.
+var allowedOrigins = func() map[string]bool {
+	list := os.Getenv("WS_ALLOWED_ORIGINS")
+	if list == "" {
+		list = "http://localhost:3000"
+	}
 
-		// 🔑 token from query param
-		tokenStr := c.Query("token")
-		if tokenStr == "" {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
+	origins := map[string]bool{}
+	for _, o := range strings.Split(list, ",") {
+		origins[strings.TrimRight(strings.TrimSpace(o), "/")] = true
+	}
+	return origins
+}()
 
-		// 🔐 validate JWT (same logic as middleware)
-		token, claims, err := auth.ValidateToken(tokenStr)
-		if err != nil || !token.Valid {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser, nothing to protect against
+	}
+	return allowedOrigins[origin]
+}
 
-		// 🔑 extract user_id
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+// HandleWebSocket authenticates with a ticket from POST /api/ws/ticket,
+// either as ?ticket= or as a first frame {"type":"auth","ticket":"..."}.
+func HandleWebSocket(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var userID, sessionID string
+
+		// 🎟️ ticket from query param
+		if ticket := c.Query("ticket"); ticket != "" {
+			var err error
+			userID, sessionID, err = auth.RedeemWSTicket(ticket)
+			if err != nil {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
 		}
 
 		// 🔌 upgrade connection
@@ -45,10 +66,24 @@ func HandleWebSocket(hub *Hub) gin.HandlerFunc {
 			return
 		}
 
+		// 🎟️ otherwise the ticket must be the first frame
+		if userID == "" {
+			userID, sessionID, err = readAuthFrame(conn)
+			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "unauthorized"))
+				conn.Close()
+				return
+			}
+		}
+
+		go auth.TouchSession(sessionID)
+
 		client := &Client{
-			UserID: userID,
-			Conn:   conn,
-			Send:   make(chan []byte),
+			UserID:    userID,
+			SessionID: sessionID,
+			Conn:      conn,
+			Send:      make(chan []byte, sendBufferSize),
 		}
 
 		hub.Register <- client
@@ -57,3 +92,23 @@ func HandleWebSocket(hub *Hub) gin.HandlerFunc {
 		go readPump(hub, client)
 	}
 }
+
+func readAuthFrame(conn *websocket.Conn) (userID, sessionID string, err error) {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var frame struct {
+		Type   string `json:"type"`
+		Ticket string `json:"ticket"`
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", "", err
+	}
+	if err := json.Unmarshal(data, &frame); err != nil || frame.Type != "auth" {
+		return "", "", auth.ErrInvalidWSTicket
+	}
+
+	return auth.RedeemWSTicket(frame.Ticket)
+}
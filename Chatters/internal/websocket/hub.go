@@ -1,147 +1,507 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"slices"
 
-	"messenger/internal/db"
+	"messenger/internal/pubsub"
 )
 
 var GlobalHub *Hub
 
+// ErrNotMember is returned by Send for a sender outside the chat.
+var ErrNotMember = errors.New("not a chat member")
+
+const (
+	// persistence workers started by NewHub
+	defaultWorkers = 16
+	// queue depth of each persistence worker
+	workerQueueSize = 256
+	// buffered frames per client before it is considered stuck
+	sendBufferSize = 256
+)
+
 type Hub struct {
-	Clients    map[string]*Client
+	// user id -> every connection of that user (sockets, SSE streams, pollers)
+	Clients    map[string]map[*Client]bool
 	Register   chan *Client
 	Unregister chan *Client
 	Incoming   chan ChatMessage
+
+	// OnPresence, if set, is called when a user's first connection to any
+	// instance opens and when their last one closes, once across all
+	// instances and in order. See presence.go.
+	OnPresence func(userID string, online bool)
+
+	store     Store
+	members   *memberCache
+	blocks    *blockCache
+	mutes     *muteCache
+	backplane pubsub.Backplane
+	workers   []chan job
+	deliver   chan delivery
+	kick      chan event
+
+	instance     string
+	online       map[string]map[string]bool // user -> instances, owned by receive
+	presenceOut  chan event
+	presenceIn   chan presenceChange
+	presenceSync chan struct{}
 }
 
 type ChatMessage struct {
-	Type      string `json:"type"`
-	ID        int    `json:"id"`
+	Type            string   `json:"type"`
+	ID              int      `json:"id"`
+	ChatID          string   `json:"chat_id"`
+	From            string   `json:"from"`
+	FromUsername    string   `json:"from_username"`
+	FromDisplayName string   `json:"from_display_name"`
+	Content         string   `json:"content"`
+	CreatedAt       string   `json:"created_at"`
+	Status          string   `json:"status"`
+	Filename        string   `json:"filename,omitempty"`
+	Forward         *Forward `json:"forwarded_from,omitempty"`
+}
+
+// Forward points at the message a forwarded one was copied from.
+type Forward struct {
+	MessageID int    `json:"message_id"`
 	ChatID    string `json:"chat_id"`
 	From      string `json:"from"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	Status    string `json:"status"`
-	Filename  string `json:"filename,omitempty"`
 }
 
-func NewHub() *Hub {
+// job is a unit of work for a persistence worker. When msg is set it is
+// stored first and the stored row is broadcast, otherwise payload is sent
+// as-is to the chat members. Members who blocked from don't get it. notify
+// marks new content, which members who muted the chat get silently. reply,
+// if set, receives the stored row.
+type job struct {
+	chatID  string
+	from    string
+	msg     *ChatMessage
+	payload []byte
+	notify  bool
+	reply   chan sendResult
+}
+
+type sendResult struct {
+	msg ChatMessage
+	err error
+}
+
+// delivery is a ready-to-send frame handed back to the hub loop.
+type delivery struct {
+	members []string
+	data    []byte
+}
+
+// event is what instances exchange over the backplane.
+type event struct {
+	Kind      string          `json:"kind"` // deliver, invalidate_chat, invalidate_user, invalidate_blocks, invalidate_mutes, disconnect, presence, presence_sync
+	Members   []string        `json:"members,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ChatID    string          `json:"chat_id,omitempty"`
+	UserID    string          `json:"user_id,omitempty"`
+	SessionID string          `json:"session_id,omitempty"`
+	// disconnect: spare this session when kicking every other one
+	KeepSessionID string `json:"keep_session_id,omitempty"`
+	// presence: the instance the user connected to or left, and whether
+	// the event only replays state for a presence_sync
+	Online   bool   `json:"online,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Replay   bool   `json:"replay,omitempty"`
+}
+
+func NewHub(backplane pubsub.Backplane) *Hub {
+	return NewHubWithStore(pgStore{}, backplane, defaultWorkers)
+}
+
+// NewHubWithStore builds a hub backed by store with the given number of
+// persistence workers. Messages of one chat always land on the same worker,
+// so they are stored and published in the order they arrived.
+func NewHubWithStore(store Store, backplane pubsub.Backplane, workers int) *Hub {
+	if workers < 1 {
+		workers = 1
+	}
+
 	h := &Hub{
-		Clients:    make(map[string]*Client),
+		Clients:    make(map[string]map[*Client]bool),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Incoming:   make(chan ChatMessage),
+		store:      store,
+		members:    newMemberCache(store),
+		blocks:     newBlockCache(store),
+		mutes:      newMuteCache(store),
+		backplane:  backplane,
+		workers:    make([]chan job, workers),
+		deliver:    make(chan delivery, workerQueueSize),
+		kick:       make(chan event),
+
+		instance:     newInstanceID(),
+		online:       make(map[string]map[string]bool),
+		presenceOut:  make(chan event, workerQueueSize),
+		presenceIn:   make(chan presenceChange, workerQueueSize),
+		presenceSync: make(chan struct{}, 1),
+	}
+	for i := range h.workers {
+		h.workers[i] = make(chan job, workerQueueSize)
 	}
 	GlobalHub = h
 	return h
 }
 
-func (h *Hub) getChatMembers(chatID string) ([]string, error) {
-	rows, err := db.DB.Query(
-		`SELECT user_id FROM chat_members WHERE chat_id = $1`,
-		chatID,
-	)
+// Run owns the connection state. All database work happens in the worker
+// pool, the loop below only registers clients and fans frames out to the
+// ones connected to this instance.
+func (h *Hub) Run() {
+	events, err := h.backplane.Subscribe()
 	if err != nil {
-		return nil, err
+		log.Fatalf("hub backplane: %v", err)
 	}
-	defer rows.Close()
 
-	var members []string
-	for rows.Next() {
-		var id string
-		_ = rows.Scan(&id)
-		members = append(members, id)
+	for _, queue := range h.workers {
+		go h.work(queue)
 	}
-	return members, nil
-}
+	go h.dispatch()
+	go h.receive(events)
+	go h.publishPresence()
+	go h.notifyPresence()
+
+	// learn who is connected to the instances already running
+	h.presenceOut <- event{Kind: "presence_sync", Instance: h.instance}
 
-func (h *Hub) Run() {
 	for {
 		select {
 
 		case c := <-h.Register:
-			h.Clients[c.UserID] = c
+			if h.Clients[c.UserID] == nil {
+				h.Clients[c.UserID] = make(map[*Client]bool)
+				h.localPresence(c.UserID, true, false)
+			}
+			h.Clients[c.UserID][c] = true
+
+		case <-h.presenceSync:
+			for userID := range h.Clients {
+				h.localPresence(userID, true, true)
+			}
 
 		case c := <-h.Unregister:
-			delete(h.Clients, c.UserID)
-			close(c.Send)
+			h.remove(c)
 
-		case msg := <-h.Incoming:
+		case d := <-h.deliver:
+			for _, userID := range d.members {
+				for client := range h.Clients[userID] {
+					select {
+					case client.Send <- d.data:
+					default:
+						// client can't keep up, drop it instead of stalling everyone
+						h.remove(client)
+					}
+				}
+			}
 
-			var id int
-			var createdAt string
+		case e := <-h.kick:
+			for client := range h.Clients[e.UserID] {
+				if e.KeepSessionID != "" && client.SessionID == e.KeepSessionID {
+					continue
+				}
+				if e.SessionID == "" || client.SessionID == e.SessionID {
+					h.remove(client)
+				}
+			}
+		}
+	}
+}
+
+func (h *Hub) remove(c *Client) {
+	conns := h.Clients[c.UserID]
+	if !conns[c] {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.Clients, c.UserID)
+		h.localPresence(c.UserID, false, false)
+	}
+	close(c.Send)
+}
+
+func (h *Hub) dispatch() {
+	for msg := range h.Incoming {
+		h.enqueue(job{chatID: msg.ChatID, from: msg.From, msg: &msg, notify: true})
+	}
+}
+
+// Send stores a text message and broadcasts it like one received over a
+// socket, but waits for the insert and returns the stored message. It goes
+// through the chat's worker, so ordering with socket messages is kept.
+// If ctx ends first Send returns its error; a message already queued may
+// still be stored and delivered.
+func (h *Hub) Send(ctx context.Context, chatID, from, content string) (ChatMessage, error) {
+	return h.SendForward(ctx, chatID, from, content, nil)
+}
 
-			err := db.DB.QueryRow(
-				`INSERT INTO messages (chat_id, sender_id, content)
-				 VALUES ($1, $2, $3)
-				 RETURNING id, created_at`,
-				msg.ChatID, msg.From, msg.Content,
-			).Scan(&id, &createdAt)
+// SendForward is Send for a message copied from elsewhere.
+func (h *Hub) SendForward(ctx context.Context, chatID, from, content string, fwd *Forward) (ChatMessage, error) {
+	reply := make(chan sendResult, 1)
+	j := job{
+		chatID: chatID,
+		from:   from,
+		msg:    &ChatMessage{ChatID: chatID, From: from, Content: content, Forward: fwd},
+		notify: true,
+		reply:  reply,
+	}
+
+	select {
+	case h.queueFor(chatID) <- j:
+	case <-ctx.Done():
+		return ChatMessage{}, ctx.Err()
+	}
+
+	select {
+	case res := <-reply:
+		return res.msg, res.err
+	case <-ctx.Done():
+		return ChatMessage{}, ctx.Err()
+	}
+}
+
+func (h *Hub) enqueue(j job) {
+	h.queueFor(j.chatID) <- j
+}
 
+// queueFor picks the worker that owns the chat.
+func (h *Hub) queueFor(chatID string) chan job {
+	hash := fnv.New32a()
+	hash.Write([]byte(chatID))
+	return h.workers[hash.Sum32()%uint32(len(h.workers))]
+}
+
+func (h *Hub) work(queue chan job) {
+	for j := range queue {
+		data := j.payload
+
+		members, err := h.members.get(j.chatID)
+		if err != nil {
+			if j.reply != nil {
+				j.reply <- sendResult{err: err}
+			}
+			continue
+		}
+
+		if j.msg != nil {
+			// socket frames name any chat they like, only members may post
+			if !slices.Contains(members, j.msg.From) {
+				if j.reply != nil {
+					j.reply <- sendResult{err: ErrNotMember}
+				}
+				continue
+			}
+
+			// only what the sender controls, whatever else the frame carried
+			out, err := h.store.SaveMessage(ChatMessage{
+				ChatID:  j.msg.ChatID,
+				From:    j.msg.From,
+				Content: j.msg.Content,
+				Forward: j.msg.Forward,
+			})
 			if err != nil {
+				if j.reply != nil {
+					j.reply <- sendResult{err: err}
+				}
 				continue
 			}
+			out.Type = "message"
+			out.Status = "sent"
+			if j.reply != nil {
+				j.reply <- sendResult{msg: out}
+			}
+			data, _ = json.Marshal(out)
+		}
 
-			out := ChatMessage{
-				Type:      "message",
-				ID:        id,
-				ChatID:    msg.ChatID,
-				From:      msg.From,
-				Content:   msg.Content,
-				CreatedAt: createdAt,
-				Status:    "sent",
+		if j.from != "" {
+			members, err = h.withoutBlockers(members, j.from)
+			if err != nil {
+				continue
 			}
+		}
 
-			data, _ := json.Marshal(out)
-			members, _ := h.getChatMembers(msg.ChatID)
+		h.publishDelivery(j, members, data)
+	}
+}
 
-			for _, userID := range members {
-				if client, ok := h.Clients[userID]; ok {
-					client.Send <- data
-				}
-			}
+// publishDelivery sends data to members. Messages and media go out with
+// "silent": true to the members who muted the chat, who still get them but
+// shouldn't be notified.
+func (h *Hub) publishDelivery(j job, members []string, data []byte) {
+	if !j.notify {
+		h.publish(event{Kind: "deliver", Members: members, Data: data})
+		return
+	}
+
+	muted, err := h.mutes.muted(j.chatID)
+	if err != nil || len(muted) == 0 {
+		h.publish(event{Kind: "deliver", Members: members, Data: data})
+		return
+	}
+
+	var loud, quiet []string
+	for _, m := range members {
+		if muted[m] {
+			quiet = append(quiet, m)
+		} else {
+			loud = append(loud, m)
+		}
+	}
+
+	if len(loud) > 0 {
+		h.publish(event{Kind: "deliver", Members: loud, Data: data})
+	}
+	if len(quiet) > 0 {
+		var frame map[string]any
+		if json.Unmarshal(data, &frame) == nil {
+			frame["silent"] = true
+			data, _ = json.Marshal(frame)
+		}
+		h.publish(event{Kind: "deliver", Members: quiet, Data: data})
+	}
+}
+
+// withoutBlockers drops the members who blocked from.
+func (h *Hub) withoutBlockers(members []string, from string) ([]string, error) {
+	blockers, err := h.blocks.get(from)
+	if err != nil || len(blockers) == 0 {
+		return members, err
+	}
+
+	kept := make([]string, 0, len(members))
+	for _, m := range members {
+		if !blockers[m] {
+			kept = append(kept, m)
+		}
+	}
+	return kept, nil
+}
+
+func (h *Hub) publish(e event) {
+	payload, _ := json.Marshal(e)
+	if err := h.backplane.Publish(payload); err != nil {
+		println("BACKPLANE ERROR:", err.Error())
+	}
+}
+
+// receive applies events published by any instance, this one included.
+func (h *Hub) receive(events <-chan []byte) {
+	for payload := range events {
+		if payload == nil {
+			h.members.invalidateAll()
+			h.blocks.invalidateAll()
+			h.mutes.invalidateAll()
+
+			// presence events may have been missed too, rebuild from scratch
+			h.online = make(map[string]map[string]bool)
+			h.presenceOut <- event{Kind: "presence_sync", Instance: h.instance}
+			continue
+		}
+
+		var e event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			continue
+		}
+
+		switch e.Kind {
+		case "deliver":
+			h.deliver <- delivery{members: e.Members, data: e.Data}
+		case "invalidate_chat":
+			h.members.invalidateChat(e.ChatID)
+		case "invalidate_user":
+			h.members.invalidateUser(e.UserID)
+		case "invalidate_blocks":
+			h.blocks.invalidate(e.UserID)
+		case "invalidate_mutes":
+			h.mutes.invalidateChat(e.ChatID)
+		case "disconnect":
+			h.kick <- e
+		case "presence":
+			h.applyPresence(e)
+		case "presence_sync":
+			h.requestPresenceSync()
 		}
 	}
 }
 
-func (h *Hub) BroadcastSeen(chatID string, messageIDs []int) {
+// InvalidateChat drops the cached member list of a chat on every instance.
+// Call it after inserting into or deleting from chat_members.
+func (h *Hub) InvalidateChat(chatID string) {
+	h.members.invalidateChat(chatID)
+	h.publish(event{Kind: "invalidate_chat", ChatID: chatID})
+}
+
+// InvalidateUser drops every cached chat the user is a member of.
+func (h *Hub) InvalidateUser(userID string) {
+	h.members.invalidateUser(userID)
+	h.publish(event{Kind: "invalidate_user", UserID: userID})
+}
+
+// InvalidateBlocks drops the cached blockers of a user on every instance.
+// Call it after blocking or unblocking them.
+func (h *Hub) InvalidateBlocks(userID string) {
+	h.blocks.invalidate(userID)
+	h.publish(event{Kind: "invalidate_blocks", UserID: userID})
+}
+
+// InvalidateMutes drops the cached mutes of a chat on every instance.
+// Call it after a member mutes or unmutes it.
+func (h *Hub) InvalidateMutes(chatID string) {
+	h.mutes.invalidateChat(chatID)
+	h.publish(event{Kind: "invalidate_mutes", ChatID: chatID})
+}
+
+// DisconnectSession closes every connection opened with the session, on
+// all instances.
+func (h *Hub) DisconnectSession(userID, sessionID string) {
+	h.publish(event{Kind: "disconnect", UserID: userID, SessionID: sessionID})
+}
+
+// DisconnectUser closes every connection of the user, on all instances.
+func (h *Hub) DisconnectUser(userID string) {
+	h.publish(event{Kind: "disconnect", UserID: userID})
+}
+
+// DisconnectOtherSessions closes the user's connections except the ones
+// opened with keepSessionID.
+func (h *Hub) DisconnectOtherSessions(userID, keepSessionID string) {
+	h.publish(event{Kind: "disconnect", UserID: userID, KeepSessionID: keepSessionID})
+}
+
+// BroadcastSeen tells the chat that reader has seen the messages. Members
+// who blocked the reader don't hear about it.
+func (h *Hub) BroadcastSeen(chatID, reader string, messageIDs []int) {
 	payload, _ := json.Marshal(map[string]interface{}{
 		"type":        "seen",
 		"chat_id":     chatID,
 		"message_ids": messageIDs,
 	})
 
-	members, _ := h.getChatMembers(chatID)
-
-	for _, userID := range members {
-		if client, ok := h.Clients[userID]; ok {
-			client.Send <- payload
-		}
-	}
+	h.enqueue(job{chatID: chatID, from: reader, payload: payload})
 }
 
-func (h *Hub) BroadcastMedia(
-	chatID string,
-	mediaID int,
-	filename string,
-	from string,
-	createdAt string,
-) {
-	payload, _ := json.Marshal(ChatMessage{
-		Type:      "media",
-		ID:        mediaID,
-		ChatID:    chatID,
-		From:      from,
-		Filename:  filename,
-		CreatedAt: createdAt,
-	})
+// BroadcastMedia announces a stored media message. msg carries its id,
+// chat, sender (with names), filename and timestamp.
+func (h *Hub) BroadcastMedia(msg ChatMessage) {
+	msg.Type = "media"
+	payload, _ := json.Marshal(msg)
 
-	members, _ := h.getChatMembers(chatID)
+	h.enqueue(job{chatID: msg.ChatID, from: msg.From, payload: payload, notify: true})
+}
 
-	for _, userID := range members {
-		if client, ok := h.Clients[userID]; ok {
-			client.Send <- payload
-		}
-	}
+// BroadcastToUsers sends payload to every connection of the given users,
+// for events that belong to a user rather than a chat.
+func (h *Hub) BroadcastToUsers(userIDs []string, payload []byte) {
+	h.publish(event{Kind: "deliver", Members: userIDs, Data: payload})
 }
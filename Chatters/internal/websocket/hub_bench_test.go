@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"messenger/internal/pubsub"
+)
+
+// Compares the worker pool against the single loop it replaced, with every
+// store call costing a database round-trip:
+//
+//	go test -run - -bench Dispatch ./internal/websocket
+
+const (
+	benchLatency = time.Millisecond
+	benchChats   = 64
+)
+
+// slowStore is a memStore where each call waits like a query would.
+type slowStore struct {
+	*memStore
+	latency time.Duration
+}
+
+func (s slowStore) SaveMessage(msg ChatMessage) (ChatMessage, error) {
+	time.Sleep(s.latency)
+	return s.memStore.SaveMessage(msg)
+}
+
+func (s slowStore) ChatMembers(chatID string) ([]string, error) {
+	time.Sleep(s.latency)
+	return s.memStore.ChatMembers(chatID)
+}
+
+func newSlowStore() slowStore {
+	store := newMemStore()
+	for i := 0; i < benchChats; i++ {
+		store.setMembers(benchChat(i), "writer", "reader")
+	}
+	return slowStore{memStore: store, latency: benchLatency}
+}
+
+func benchChat(i int) string {
+	return "chat-" + strconv.Itoa(i%benchChats)
+}
+
+// serialHub is Hub.Run as it was before the worker pool: the loop itself
+// inserts each message and looks up the members before fanning out, so
+// every round-trip holds up the whole hub.
+type serialHub struct {
+	store    Store
+	clients  map[string]*Client
+	incoming chan ChatMessage
+}
+
+func (h *serialHub) run() {
+	for msg := range h.incoming {
+		out, err := h.store.SaveMessage(msg)
+		if err != nil {
+			continue
+		}
+		out.Type = "message"
+		out.Status = "sent"
+
+		data, _ := json.Marshal(out)
+		members, _ := h.store.ChatMembers(msg.ChatID)
+
+		for _, userID := range members {
+			if client, ok := h.clients[userID]; ok {
+				client.Send <- data
+			}
+		}
+	}
+}
+
+// drive sends b.N messages spread over the chats and waits until the
+// reader got all of them.
+func drive(b *testing.B, incoming chan<- ChatMessage, reader *Client) {
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			incoming <- ChatMessage{ChatID: benchChat(i), From: "writer", Content: "hello"}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-reader.Send
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msg/s")
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	b.Run("serial", func(b *testing.B) {
+		reader := &Client{UserID: "reader", Send: make(chan []byte, b.N)}
+		h := &serialHub{
+			store:    newSlowStore(),
+			clients:  map[string]*Client{"reader": reader},
+			incoming: make(chan ChatMessage),
+		}
+		go h.run()
+		defer close(h.incoming)
+
+		drive(b, h.incoming, reader)
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		h := NewHubWithStore(newSlowStore(), pubsub.NewMemory(), defaultWorkers)
+		go h.Run()
+
+		reader := &Client{UserID: "reader", Send: make(chan []byte, b.N)}
+		h.Register <- reader
+
+		drive(b, h.Incoming, reader)
+	})
+}
@@ -0,0 +1,276 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"messenger/internal/pubsub"
+)
+
+// memStore is a Store kept in memory.
+type memStore struct {
+	mu      sync.Mutex
+	nextID  int
+	members map[string][]string
+	blocked map[string][]string             // user -> users who blocked them
+	mutes   map[string]map[string]time.Time // chat -> user -> until
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		members: map[string][]string{},
+		blocked: map[string][]string{},
+		mutes:   map[string]map[string]time.Time{},
+	}
+}
+
+func (s *memStore) SaveMessage(msg ChatMessage) (ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	msg.ID = s.nextID
+	msg.CreatedAt = time.Now().Format(time.RFC3339Nano)
+	msg.FromUsername = "name-" + msg.From
+	return msg, nil
+}
+
+func (s *memStore) ChatMembers(chatID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.members[chatID]...), nil
+}
+
+func (s *memStore) BlockedBy(userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.blocked[userID]...), nil
+}
+
+func (s *memStore) ChatMutes(chatID string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mutes := map[string]time.Time{}
+	for userID, until := range s.mutes[chatID] {
+		mutes[userID] = until
+	}
+	return mutes, nil
+}
+
+func (s *memStore) setMembers(chatID string, members ...string) {
+	s.mu.Lock()
+	s.members[chatID] = members
+	s.mu.Unlock()
+}
+
+func startHub(t *testing.T, store Store, backplane pubsub.Backplane) *Hub {
+	t.Helper()
+	h := NewHubWithStore(store, backplane, 4)
+	go h.Run()
+	return h
+}
+
+func connect(h *Hub, userID string) *Client {
+	c := &Client{UserID: userID, Send: make(chan []byte, sendBufferSize)}
+	h.Register <- c
+	return c
+}
+
+// next returns the next frame sent to the client.
+func next(t *testing.T, c *Client) map[string]any {
+	t.Helper()
+	select {
+	case data, ok := <-c.Send:
+		if !ok {
+			t.Fatalf("%s: connection closed", c.UserID)
+		}
+		var frame map[string]any
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("%s: bad frame %q", c.UserID, data)
+		}
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s: no frame", c.UserID)
+	}
+	return nil
+}
+
+// nothing fails if the client gets a frame within a short wait.
+func nothing(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		t.Fatalf("%s: unexpected frame %s", c.UserID, data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func send(t *testing.T, h *Hub, chatID, from, content string) ChatMessage {
+	t.Helper()
+	msg, err := h.Send(context.Background(), chatID, from, content)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	return msg
+}
+
+func TestSendReachesMembersOnEveryInstance(t *testing.T) {
+	store := newMemStore()
+	store.setMembers("c1", "alice", "bob")
+	backplane := pubsub.NewMemory()
+	a := startHub(t, store, backplane)
+	b := startHub(t, store, backplane)
+
+	alice := connect(a, "alice")
+	bob := connect(b, "bob")
+
+	msg := send(t, a, "c1", "alice", "hi")
+	if msg.ID == 0 || msg.Type != "message" || msg.FromUsername != "name-alice" {
+		t.Fatalf("stored message = %+v", msg)
+	}
+
+	for _, c := range []*Client{alice, bob} {
+		frame := next(t, c)
+		if frame["content"] != "hi" || frame["from_username"] != "name-alice" {
+			t.Fatalf("%s got %v", c.UserID, frame)
+		}
+	}
+}
+
+func TestSendRefusesNonMembers(t *testing.T) {
+	store := newMemStore()
+	store.setMembers("saved", "alice")
+	h := startHub(t, store, pubsub.NewMemory())
+
+	alice := connect(h, "alice")
+
+	_, err := h.Send(context.Background(), "saved", "mallory", "hi")
+	if !errors.Is(err, ErrNotMember) {
+		t.Fatalf("Send = %v, want ErrNotMember", err)
+	}
+	if store.nextID != 0 {
+		t.Fatalf("stored %d messages", store.nextID)
+	}
+	nothing(t, alice)
+}
+
+func TestInvalidateChatReachesOtherInstances(t *testing.T) {
+	store := newMemStore()
+	store.setMembers("c1", "alice", "bob")
+	backplane := pubsub.NewMemory()
+	a := startHub(t, store, backplane)
+	b := startHub(t, store, backplane)
+
+	bob := connect(b, "bob")
+	carol := connect(b, "carol")
+
+	// b caches the members
+	send(t, b, "c1", "alice", "one")
+	next(t, bob)
+	nothing(t, carol)
+
+	store.setMembers("c1", "alice", "bob", "carol")
+	a.InvalidateChat("c1")
+	// published after the invalidation, so b has applied it once bob sees it
+	send(t, a, "c1", "alice", "two")
+	next(t, bob)
+	next(t, carol)
+
+	send(t, b, "c1", "alice", "three")
+	if frame := next(t, carol); frame["content"] != "three" {
+		t.Fatalf("carol got %v", frame)
+	}
+}
+
+func TestDisconnectUserReachesOtherInstances(t *testing.T) {
+	backplane := pubsub.NewMemory()
+	a := startHub(t, newMemStore(), backplane)
+	b := startHub(t, newMemStore(), backplane)
+
+	bob := connect(b, "bob")
+	a.DisconnectUser("bob")
+
+	select {
+	case _, ok := <-bob.Send:
+		if ok {
+			t.Fatal("bob got a frame instead of being disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("bob is still connected")
+	}
+}
+
+type presenceRecorder chan presenceChange
+
+func (r presenceRecorder) record(userID string, online bool) {
+	r <- presenceChange{userID: userID, online: online}
+}
+
+func (r presenceRecorder) expect(t *testing.T, want presenceChange) {
+	t.Helper()
+	select {
+	case got := <-r:
+		if got != want {
+			t.Fatalf("presence %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no presence change, want %+v", want)
+	}
+}
+
+func (r presenceRecorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r:
+		t.Fatalf("unexpected presence change %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPresenceIsGlobalAcrossInstances(t *testing.T) {
+	backplane := pubsub.NewMemory()
+	var hubs [2]*Hub
+	var seen [2]presenceRecorder
+	for i := range hubs {
+		seen[i] = make(presenceRecorder, 16)
+		hubs[i] = NewHubWithStore(newMemStore(), backplane, 1)
+		hubs[i].OnPresence = seen[i].record
+		go hubs[i].Run()
+	}
+
+	onA := connect(hubs[0], "bob")
+	seen[0].expect(t, presenceChange{"bob", true})
+
+	// still online through b, nobody reports anything; the instances
+	// publish independently, so give b's event time to go out first
+	onB := connect(hubs[1], "bob")
+	time.Sleep(50 * time.Millisecond)
+	hubs[0].Unregister <- onA
+	seen[0].none(t)
+	seen[1].none(t)
+
+	// the last connection anywhere closing is what takes bob offline
+	hubs[1].Unregister <- onB
+	seen[1].expect(t, presenceChange{"bob", false})
+	seen[0].none(t)
+}
+
+func TestPresenceSyncTeachesNewInstances(t *testing.T) {
+	backplane := pubsub.NewMemory()
+	a := startHub(t, newMemStore(), backplane)
+	connect(a, "bob")
+
+	// b starts after bob connected to a
+	seen := make(presenceRecorder, 16)
+	b := NewHubWithStore(newMemStore(), backplane, 1)
+	b.OnPresence = seen.record
+	go b.Run()
+
+	// b learned bob is online, so a second connection isn't news
+	time.Sleep(50 * time.Millisecond)
+	connect(b, "bob")
+	seen.none(t)
+}
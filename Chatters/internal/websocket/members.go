@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	memberCacheHits   = expvar.NewInt("member_cache_hits")
+	memberCacheMisses = expvar.NewInt("member_cache_misses")
+)
+
+func init() {
+	expvar.Publish("member_cache_hit_rate", expvar.Func(func() any {
+		hits, misses := memberCacheHits.Value(), memberCacheMisses.Value()
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+// memberCache keeps chat_members in memory so broadcasts don't query
+// Postgres for every frame. Anything that writes chat_members must
+// invalidate the affected chats through the hub.
+type memberCache struct {
+	store Store
+
+	mu    sync.RWMutex
+	chats map[string][]string
+	// bumped on every invalidation so a load that raced with a write
+	// doesn't put stale members back
+	version uint64
+}
+
+func newMemberCache(store Store) *memberCache {
+	return &memberCache{
+		store: store,
+		chats: make(map[string][]string),
+	}
+}
+
+func (m *memberCache) get(chatID string) ([]string, error) {
+	m.mu.RLock()
+	members, ok := m.chats[chatID]
+	version := m.version
+	m.mu.RUnlock()
+
+	if ok {
+		memberCacheHits.Add(1)
+		return members, nil
+	}
+	memberCacheMisses.Add(1)
+
+	members, err := m.store.ChatMembers(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if m.version == version {
+		m.chats[chatID] = members
+	}
+	m.mu.Unlock()
+
+	return members, nil
+}
+
+func (m *memberCache) invalidateChat(chatID string) {
+	m.mu.Lock()
+	delete(m.chats, chatID)
+	m.version++
+	m.mu.Unlock()
+}
+
+func (m *memberCache) invalidateUser(userID string) {
+	m.mu.Lock()
+	for chatID, members := range m.chats {
+		for _, member := range members {
+			if member == userID {
+				delete(m.chats, chatID)
+				break
+			}
+		}
+	}
+	m.version++
+	m.mu.Unlock()
+}
+
+func (m *memberCache) invalidateAll() {
+	m.mu.Lock()
+	m.chats = make(map[string][]string)
+	m.version++
+	m.mu.Unlock()
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// muteCache keeps who muted each chat, and until when (zero for until
+// unmuted), so deliveries can be marked silent without a query per frame.
+// Anything that writes chat_settings.muted must invalidate the chat
+// through the hub.
+type muteCache struct {
+	store Store
+
+	mu    sync.RWMutex
+	chats map[string]map[string]time.Time
+	// same role as memberCache.version
+	version uint64
+}
+
+func newMuteCache(store Store) *muteCache {
+	return &muteCache{
+		store: store,
+		chats: make(map[string]map[string]time.Time),
+	}
+}
+
+// muted returns the members of the chat that have it muted right now.
+func (m *muteCache) muted(chatID string) (map[string]bool, error) {
+	m.mu.RLock()
+	mutes, ok := m.chats[chatID]
+	version := m.version
+	m.mu.RUnlock()
+
+	if !ok {
+		var err error
+		mutes, err = m.store.ChatMutes(chatID)
+		if err != nil {
+			return nil, err
+		}
+
+		m.mu.Lock()
+		if m.version == version {
+			m.chats[chatID] = mutes
+		}
+		m.mu.Unlock()
+	}
+
+	now := time.Now()
+	muted := make(map[string]bool, len(mutes))
+	for userID, until := range mutes {
+		if until.IsZero() || until.After(now) {
+			muted[userID] = true
+		}
+	}
+	return muted, nil
+}
+
+func (m *muteCache) invalidateChat(chatID string) {
+	m.mu.Lock()
+	delete(m.chats, chatID)
+	m.version++
+	m.mu.Unlock()
+}
+
+func (m *muteCache) invalidateAll() {
+	m.mu.Lock()
+	m.chats = make(map[string]map[string]time.Time)
+	m.version++
+	m.mu.Unlock()
+}
@@ -1,18 +1,117 @@
 package websocket
 
 import (
-	"messenger/internal/db"
+	"database/sql"
+	"time"
 
-	"github.com/google/uuid"
+	"messenger/internal/db"
 )
 
-func saveMessage(msg ChatMessage) {
-	db.DB.Exec(
-		`INSERT INTO messages (id, chat_id, sender_id, content)
-		 VALUES ($1, $2, $3, $4)`,
-		uuid.New(),
-		msg.ChatID,
-		msg.From,
-		msg.Content,
+// Store is the persistence the hub workers depend on.
+type Store interface {
+	// SaveMessage stores msg (ChatID, From, Content and Forward) and returns
+	// it with the id, timestamp and sender's names filled in
+	SaveMessage(msg ChatMessage) (ChatMessage, error)
+	ChatMembers(chatID string) ([]string, error)
+	// BlockedBy lists the users who blocked userID
+	BlockedBy(userID string) ([]string, error)
+	// ChatMutes maps members who muted the chat to the end of the mute,
+	// zero when it has none
+	ChatMutes(chatID string) (map[string]time.Time, error)
+}
+
+type pgStore struct{}
+
+func (pgStore) SaveMessage(msg ChatMessage) (ChatMessage, error) {
+	var fwdID *int
+	var fwdChat, fwdFrom *string
+	if fwd := msg.Forward; fwd != nil {
+		fwdID, fwdChat = &fwd.MessageID, &fwd.ChatID
+		if fwd.From != "" {
+			fwdFrom = &fwd.From
+		}
+	}
+
+	err := db.DB.QueryRow(
+		`WITH ins AS (
+		   INSERT INTO messages (chat_id, sender_id, content,
+		     forward_message_id, forward_chat_id, forward_sender_id)
+		   VALUES ($1, $2, $3, $4, $5, $6)
+		   RETURNING id, created_at, sender_id
+		 )
+		 SELECT ins.id, ins.created_at, u.username, u.display_name
+		 FROM ins JOIN users u ON u.id = ins.sender_id`,
+		msg.ChatID, msg.From, msg.Content, fwdID, fwdChat, fwdFrom,
+	).Scan(&msg.ID, &msg.CreatedAt, &msg.FromUsername, &msg.FromDisplayName)
+
+	return msg, err
+}
+
+func (pgStore) ChatMembers(chatID string) ([]string, error) {
+	rows, err := db.DB.Query(
+		`SELECT user_id FROM chat_members WHERE chat_id = $1`,
+		chatID,
 	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var id string
+		_ = rows.Scan(&id)
+		members = append(members, id)
+	}
+	return members, nil
+}
+
+func (pgStore) BlockedBy(userID string) ([]string, error) {
+	rows, err := db.DB.Query(
+		`SELECT blocker_id FROM blocks WHERE blocked_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blockers []string
+	for rows.Next() {
+		var id string
+		_ = rows.Scan(&id)
+		blockers = append(blockers, id)
+	}
+	return blockers, nil
+}
+
+func (pgStore) ChatMutes(chatID string) (map[string]time.Time, error) {
+	rows, err := db.DB.Query(
+		`SELECT user_id, EXTRACT(EPOCH FROM muted_until - now())
+		 FROM chat_settings
+		 WHERE chat_id = $1 AND muted`,
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// the database reports what's left of each mute so its time zone
+	// never matters here
+	now := time.Now()
+	mutes := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var left sql.NullFloat64
+		if err := rows.Scan(&id, &left); err != nil {
+			return nil, err
+		}
+		if left.Valid {
+			mutes[id] = now.Add(time.Duration(left.Float64 * float64(time.Second)))
+		} else {
+			mutes[id] = time.Time{}
+		}
+	}
+	return mutes, rows.Err()
 }
@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// how long a poll request waits for the first frame
+	pollWait = 25 * time.Second
+	// pollers not polled for this long are unregistered
+	pollIdle = time.Minute
+)
+
+// poller is a hub client that buffers frames between long-poll requests.
+type poller struct {
+	client   *Client
+	hub      *Hub
+	mu       sync.Mutex // one request drains the buffer at a time
+	lastPoll time.Time
+}
+
+var (
+	pollersMu  sync.Mutex
+	pollers    = map[string]*poller{}
+	reaperOnce sync.Once
+)
+
+// HandlePoll is the long-polling fallback. The first request omits poll_id
+// and gets one back; later requests pass it to receive the frames buffered
+// since the previous poll.
+func HandlePoll(hub *Hub) gin.HandlerFunc {
+	reaperOnce.Do(func() { go reapPollers() })
+
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		pollID := c.Query("poll_id")
+
+		pollersMu.Lock()
+		p, ok := pollers[pollID]
+		if pollID != "" && (!ok || p.client.UserID != userID) {
+			pollersMu.Unlock()
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown poll_id"})
+			return
+		}
+		if !ok {
+			pollID = uuid.NewString()
+			p = &poller{
+				client: &Client{
+					UserID:    userID,
+					SessionID: c.GetString("session_id"),
+					Send:      make(chan []byte, sendBufferSize),
+				},
+				hub: hub,
+			}
+			pollers[pollID] = p
+			hub.Register <- p.client
+		}
+		p.lastPoll = time.Now()
+		pollersMu.Unlock()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		events := []json.RawMessage{}
+		timeout := time.NewTimer(pollWait)
+		defer timeout.Stop()
+
+		// wait for one frame, then take whatever else is already buffered
+		select {
+		case msg, ok := <-p.client.Send:
+			if !ok {
+				dropPoller(pollID)
+				c.JSON(http.StatusGone, gin.H{"error": "poll expired, start a new one"})
+				return
+			}
+			events = append(events, msg)
+		case <-timeout.C:
+		case <-c.Request.Context().Done():
+			return
+		}
+
+	drain:
+		for {
+			select {
+			case msg, ok := <-p.client.Send:
+				if !ok {
+					break drain
+				}
+				events = append(events, msg)
+			default:
+				break drain
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"poll_id": pollID,
+			"events":  events,
+		})
+	}
+}
+
+func dropPoller(pollID string) {
+	pollersMu.Lock()
+	delete(pollers, pollID)
+	pollersMu.Unlock()
+}
+
+func reapPollers() {
+	for range time.Tick(pollIdle / 2) {
+		pollersMu.Lock()
+		for id, p := range pollers {
+			if time.Since(p.lastPoll) > pollIdle {
+				delete(pollers, id)
+				go func(p *poller) { p.hub.Unregister <- p.client }(p)
+			}
+		}
+		pollersMu.Unlock()
+	}
+}
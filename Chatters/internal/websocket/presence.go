@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"github.com/google/uuid"
+)
+
+// Presence is worked out across instances. Each instance publishes when a
+// user's first connection to it opens or their last one closes, and every
+// instance folds those events, in backplane order, into the same view of
+// which instances each user is connected to. OnPresence runs only on the
+// instance whose event took the user from no connections to some or back,
+// so it fires once per change however many instances are running.
+//
+// An instance joining, or reconnecting to the backplane, asks the others
+// to replay their connected users. An instance that dies without closing
+// its connections leaves its users online until it comes back.
+
+// presenceChange is a call to OnPresence waiting its turn.
+type presenceChange struct {
+	userID string
+	online bool
+}
+
+func newInstanceID() string {
+	return uuid.NewString()
+}
+
+// publishPresence sends this instance's presence events in the order the
+// hub loop produced them.
+func (h *Hub) publishPresence() {
+	for e := range h.presenceOut {
+		h.publish(e)
+	}
+}
+
+// notifyPresence calls OnPresence one change at a time, so a quick
+// reconnect can't be reported as online before offline.
+func (h *Hub) notifyPresence() {
+	for p := range h.presenceIn {
+		if h.OnPresence != nil {
+			h.OnPresence(p.userID, p.online)
+		}
+	}
+}
+
+// applyPresence folds a presence event into the global view. Called from
+// receive only.
+func (h *Hub) applyPresence(e event) {
+	instances := h.online[e.UserID]
+	wasOnline := len(instances) > 0
+
+	if e.Online {
+		if instances == nil {
+			instances = make(map[string]bool)
+			h.online[e.UserID] = instances
+		}
+		instances[e.Instance] = true
+	} else {
+		delete(instances, e.Instance)
+		if len(instances) == 0 {
+			delete(h.online, e.UserID)
+		}
+	}
+
+	isOnline := len(h.online[e.UserID]) > 0
+	if wasOnline != isOnline && !e.Replay && e.Instance == h.instance {
+		h.presenceIn <- presenceChange{userID: e.UserID, online: isOnline}
+	}
+}
+
+// requestPresenceSync has the hub loop replay this instance's users. It
+// never blocks receive; requests that pile up are served once.
+func (h *Hub) requestPresenceSync() {
+	select {
+	case h.presenceSync <- struct{}{}:
+	default:
+	}
+}
+
+// localPresence queues a presence event about a connection on this
+// instance.
+func (h *Hub) localPresence(userID string, online, replay bool) {
+	h.presenceOut <- event{
+		Kind:     "presence",
+		UserID:   userID,
+		Online:   online,
+		Replay:   replay,
+		Instance: h.instance,
+	}
+}
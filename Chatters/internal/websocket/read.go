@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"messenger/internal/db"
+)
+
+// MarkRead moves the user's read position in the chat past its latest
+// message and media. Unless they turned read receipts off, the others'
+// messages are also marked seen and the chat is told. Users who aren't
+// members of the chat are ignored.
+func (h *Hub) MarkRead(chatID, userID string) error {
+	res, err := db.DB.Exec(
+		`UPDATE chat_members SET
+		   last_read_message_id = GREATEST(last_read_message_id,
+		     COALESCE((SELECT MAX(id) FROM messages WHERE chat_id = $1), 0)),
+		   last_read_media_id = GREATEST(last_read_media_id,
+		     COALESCE((SELECT MAX(id) FROM media_messages WHERE chat_id = $1), 0))
+		 WHERE chat_id = $1 AND user_id = $2`,
+		chatID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	rows, err := db.DB.Query(
+		`UPDATE messages
+		 SET status = 'seen'
+		 WHERE chat_id = $1
+		 AND sender_id != $2
+		 AND status != 'seen'
+		 AND sender_id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = $2)
+		 AND (SELECT read_receipts FROM users WHERE id = $2)
+		 RETURNING id`,
+		chatID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var seenIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		seenIDs = append(seenIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(seenIDs) > 0 {
+		h.BroadcastSeen(chatID, userID, seenIDs)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keeps proxies from closing an idle stream
+const sseKeepAlive = 25 * time.Second
+
+// HandleSSE streams the hub frames of the authenticated user as
+// Server-Sent Events, for clients that can't open a WebSocket.
+func HandleSSE(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := &Client{
+			UserID:    c.GetString("user_id"),
+			SessionID: c.GetString("session_id"),
+			Send:      make(chan []byte, sendBufferSize),
+		}
+		hub.Register <- client
+		defer func() {
+			hub.Unregister <- client
+		}()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+		c.Status(http.StatusOK)
+
+		ping := time.NewTicker(sseKeepAlive)
+		defer ping.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case msg, ok := <-client.Send:
+				if !ok {
+					return false
+				}
+				fmt.Fprintf(w, "data: %s\n\n", msg)
+				return true
+
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+				return true
+
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
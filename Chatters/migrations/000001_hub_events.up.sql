@@ -0,0 +1,6 @@
+-- Oversized hub events that don't fit in a NOTIFY payload
+CREATE TABLE IF NOT EXISTS hub_events (
+  id BIGSERIAL PRIMARY KEY,
+  payload TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT now()
+);
@@ -0,0 +1,5 @@
+ALTER TABLE sessions
+  ADD COLUMN IF NOT EXISTS device_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP DEFAULT now();
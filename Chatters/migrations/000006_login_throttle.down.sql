@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS failed_logins;
+DROP TABLE IF EXISTS login_throttle;
+
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
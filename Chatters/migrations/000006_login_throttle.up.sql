@@ -0,0 +1,23 @@
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false;
+
+-- Failed login counters per account ("user:<id>") and per client ("ip:<addr>")
+CREATE TABLE IF NOT EXISTS login_throttle (
+  key TEXT PRIMARY KEY,
+  failures INT NOT NULL DEFAULT 0,
+  last_failure TIMESTAMP NOT NULL DEFAULT now(),
+  locked_until TIMESTAMP
+);
+
+-- Audit trail of failed logins
+CREATE TABLE IF NOT EXISTS failed_logins (
+  id BIGSERIAL PRIMARY KEY,
+  identifier TEXT NOT NULL,
+  user_id TEXT,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  reason TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS failed_logins_user_id_idx ON failed_logins (user_id, created_at);
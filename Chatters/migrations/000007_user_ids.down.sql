@@ -0,0 +1,44 @@
+-- Back to usernames as primary key. Rows whose user is gone keep NULL.
+
+ALTER TABLE chat_members DROP CONSTRAINT IF EXISTS chat_members_user_id_fkey;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_sender_id_fkey;
+ALTER TABLE media_messages DROP CONSTRAINT IF EXISTS media_messages_sender_id_fkey;
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_user_id_fkey;
+ALTER TABLE recovery_codes DROP CONSTRAINT IF EXISTS recovery_codes_user_id_fkey;
+ALTER TABLE email_tokens DROP CONSTRAINT IF EXISTS email_tokens_user_id_fkey;
+
+ALTER TABLE chat_members ALTER COLUMN user_id TYPE TEXT;
+ALTER TABLE messages ALTER COLUMN sender_id TYPE TEXT;
+ALTER TABLE media_messages ALTER COLUMN sender_id TYPE TEXT;
+ALTER TABLE sessions ALTER COLUMN user_id TYPE TEXT;
+ALTER TABLE recovery_codes ALTER COLUMN user_id TYPE TEXT;
+ALTER TABLE email_tokens ALTER COLUMN user_id TYPE TEXT;
+ALTER TABLE failed_logins ALTER COLUMN user_id TYPE TEXT;
+
+UPDATE chat_members t SET user_id = u.username FROM users u WHERE t.user_id = u.id::text;
+UPDATE messages t SET sender_id = u.username FROM users u WHERE t.sender_id = u.id::text;
+UPDATE media_messages t SET sender_id = u.username FROM users u WHERE t.sender_id = u.id::text;
+UPDATE sessions t SET user_id = u.username FROM users u WHERE t.user_id = u.id::text;
+UPDATE recovery_codes t SET user_id = u.username FROM users u WHERE t.user_id = u.id::text;
+UPDATE email_tokens t SET user_id = u.username FROM users u WHERE t.user_id = u.id::text;
+UPDATE failed_logins t SET user_id = u.username FROM users u WHERE t.user_id = u.id::text;
+DELETE FROM login_throttle WHERE key LIKE 'user:%';
+
+ALTER TABLE users DROP CONSTRAINT users_pkey;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+ALTER TABLE users DROP COLUMN id;
+ALTER TABLE users RENAME COLUMN username TO id;
+ALTER TABLE users ADD PRIMARY KEY (id);
+
+ALTER TABLE chat_members ADD CONSTRAINT chat_members_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE messages ADD CONSTRAINT messages_sender_id_fkey
+  FOREIGN KEY (sender_id) REFERENCES users(id) ON UPDATE CASCADE;
+ALTER TABLE media_messages ADD CONSTRAINT media_messages_sender_id_fkey
+  FOREIGN KEY (sender_id) REFERENCES users(id) ON UPDATE CASCADE;
+ALTER TABLE sessions ADD CONSTRAINT sessions_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE recovery_codes ADD CONSTRAINT recovery_codes_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
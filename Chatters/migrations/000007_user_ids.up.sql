@@ -0,0 +1,61 @@
+-- users.id used to be the username. Give every user an immutable UUID and
+-- keep the username as a separate, changeable column.
+
+ALTER TABLE users ADD COLUMN username TEXT;
+ALTER TABLE users ADD COLUMN new_id UUID NOT NULL DEFAULT gen_random_uuid();
+UPDATE users SET username = id;
+
+ALTER TABLE chat_members DROP CONSTRAINT IF EXISTS chat_members_user_id_fkey;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_sender_id_fkey;
+ALTER TABLE media_messages DROP CONSTRAINT IF EXISTS media_messages_sender_id_fkey;
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_user_id_fkey;
+ALTER TABLE recovery_codes DROP CONSTRAINT IF EXISTS recovery_codes_user_id_fkey;
+ALTER TABLE email_tokens DROP CONSTRAINT IF EXISTS email_tokens_user_id_fkey;
+
+-- Rows still naming a username that no longer exists (ChangeUsername never
+-- rewrote messages) can't be attributed anymore.
+UPDATE messages SET sender_id = NULL
+  WHERE sender_id NOT IN (SELECT id FROM users);
+UPDATE media_messages SET sender_id = NULL
+  WHERE sender_id NOT IN (SELECT id FROM users);
+UPDATE failed_logins SET user_id = NULL
+  WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM chat_members WHERE user_id NOT IN (SELECT id FROM users);
+
+UPDATE chat_members t SET user_id = u.new_id::text FROM users u WHERE t.user_id = u.id;
+UPDATE messages t SET sender_id = u.new_id::text FROM users u WHERE t.sender_id = u.id;
+UPDATE media_messages t SET sender_id = u.new_id::text FROM users u WHERE t.sender_id = u.id;
+UPDATE sessions t SET user_id = u.new_id::text FROM users u WHERE t.user_id = u.id;
+UPDATE recovery_codes t SET user_id = u.new_id::text FROM users u WHERE t.user_id = u.id;
+UPDATE email_tokens t SET user_id = u.new_id::text FROM users u WHERE t.user_id = u.id;
+UPDATE failed_logins t SET user_id = u.new_id::text FROM users u WHERE t.user_id = u.id;
+-- account lockouts were keyed by username
+DELETE FROM login_throttle WHERE key LIKE 'user:%';
+
+ALTER TABLE chat_members ALTER COLUMN user_id TYPE UUID USING user_id::uuid;
+ALTER TABLE messages ALTER COLUMN sender_id TYPE UUID USING sender_id::uuid;
+ALTER TABLE media_messages ALTER COLUMN sender_id TYPE UUID USING sender_id::uuid;
+ALTER TABLE sessions ALTER COLUMN user_id TYPE UUID USING user_id::uuid;
+ALTER TABLE recovery_codes ALTER COLUMN user_id TYPE UUID USING user_id::uuid;
+ALTER TABLE email_tokens ALTER COLUMN user_id TYPE UUID USING user_id::uuid;
+ALTER TABLE failed_logins ALTER COLUMN user_id TYPE UUID USING user_id::uuid;
+
+ALTER TABLE users DROP CONSTRAINT users_pkey;
+ALTER TABLE users DROP COLUMN id;
+ALTER TABLE users RENAME COLUMN new_id TO id;
+ALTER TABLE users ADD PRIMARY KEY (id);
+ALTER TABLE users ALTER COLUMN username SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+
+ALTER TABLE chat_members ADD CONSTRAINT chat_members_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD CONSTRAINT messages_sender_id_fkey
+  FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE media_messages ADD CONSTRAINT media_messages_sender_id_fkey
+  FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE sessions ADD CONSTRAINT sessions_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE recovery_codes ADD CONSTRAINT recovery_codes_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
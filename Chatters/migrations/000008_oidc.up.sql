@@ -0,0 +1,17 @@
+-- Users linked to an external OpenID Connect identity
+CREATE TABLE IF NOT EXISTS user_identities (
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT now(),
+  PRIMARY KEY (issuer, subject)
+);
+
+-- In-flight OIDC logins: state, PKCE verifier and nonce
+CREATE TABLE IF NOT EXISTS oidc_logins (
+  state TEXT PRIMARY KEY,
+  verifier TEXT NOT NULL,
+  nonce TEXT NOT NULL,
+  device_name TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,20 @@
+-- WebAuthn credentials, a user can register several
+CREATE TABLE IF NOT EXISTS passkeys (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  credential_id BYTEA UNIQUE NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  credential JSONB NOT NULL,
+  created_at TIMESTAMP DEFAULT now(),
+  last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS passkeys_user_id_idx ON passkeys (user_id);
+
+-- Challenges of registrations and logins in progress
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+  id TEXT PRIMARY KEY,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+  session JSONB NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Single-use tickets for opening a WebSocket without a JWT in the URL
+CREATE TABLE IF NOT EXISTS ws_tickets (
+  ticket_hash TEXT PRIMARY KEY,
+  session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+  expires_at TIMESTAMP NOT NULL
+);
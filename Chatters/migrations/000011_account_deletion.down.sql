@@ -0,0 +1,2 @@
+ALTER TABLE chats DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE users DROP COLUMN IF EXISTS delete_after;
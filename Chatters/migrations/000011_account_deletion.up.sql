@@ -0,0 +1,16 @@
+-- Accounts are deleted once their grace period is over
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS delete_after TIMESTAMP;
+
+-- Group owners; existing groups go to their first poster, or any member
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+UPDATE chats c SET owner_id = COALESCE(
+  (SELECT m.sender_id FROM messages m
+   JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = m.sender_id
+   WHERE m.chat_id = c.id
+   ORDER BY m.created_at LIMIT 1),
+  (SELECT user_id FROM chat_members WHERE chat_id = c.id LIMIT 1)
+)
+WHERE c.is_group;
@@ -0,0 +1,12 @@
+-- Personal data export archives, built in the background
+CREATE TABLE IF NOT EXISTS data_exports (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending',
+  file_path TEXT,
+  created_at TIMESTAMP DEFAULT now(),
+  completed_at TIMESTAMP,
+  expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id);
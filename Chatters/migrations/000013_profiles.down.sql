@@ -0,0 +1,8 @@
+ALTER TABLE users
+  DROP COLUMN IF EXISTS status_expires_at,
+  DROP COLUMN IF EXISTS status_emoji,
+  DROP COLUMN IF EXISTS status_text,
+  DROP COLUMN IF EXISTS avatar_thumb_path,
+  DROP COLUMN IF EXISTS avatar_path,
+  DROP COLUMN IF EXISTS bio,
+  DROP COLUMN IF EXISTS display_name;
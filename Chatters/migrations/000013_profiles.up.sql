@@ -0,0 +1,8 @@
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS avatar_path TEXT,
+  ADD COLUMN IF NOT EXISTS avatar_thumb_path TEXT,
+  ADD COLUMN IF NOT EXISTS status_text TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS status_emoji TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+  DROP COLUMN IF EXISTS last_seen_at,
+  DROP COLUMN IF EXISTS read_receipts,
+  DROP COLUMN IF EXISTS photo_visibility,
+  DROP COLUMN IF EXISTS last_seen_visibility,
+  DROP COLUMN IF EXISTS group_add;
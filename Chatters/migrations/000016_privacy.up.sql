@@ -0,0 +1,7 @@
+-- Privacy settings; audiences are 'everyone', 'contacts' or 'nobody'
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS group_add TEXT NOT NULL DEFAULT 'everyone',
+  ADD COLUMN IF NOT EXISTS last_seen_visibility TEXT NOT NULL DEFAULT 'everyone',
+  ADD COLUMN IF NOT EXISTS photo_visibility TEXT NOT NULL DEFAULT 'everyone',
+  ADD COLUMN IF NOT EXISTS read_receipts BOOLEAN NOT NULL DEFAULT true,
+  ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
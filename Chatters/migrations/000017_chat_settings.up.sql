@@ -0,0 +1,13 @@
+-- Per-user view of a chat: mute, archive, pin
+CREATE TABLE IF NOT EXISTS chat_settings (
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  muted BOOLEAN NOT NULL DEFAULT false,
+  -- NULL while muted means until unmuted
+  muted_until TIMESTAMP,
+  archived BOOLEAN NOT NULL DEFAULT false,
+  pinned_at TIMESTAMP,
+  PRIMARY KEY (user_id, chat_id)
+);
+
+CREATE INDEX IF NOT EXISTS chat_settings_chat_id_idx ON chat_settings (chat_id) WHERE muted;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_folder_chats;
+DROP TABLE IF EXISTS chat_folders;
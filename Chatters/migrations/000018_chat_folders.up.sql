@@ -0,0 +1,21 @@
+-- Named chat lists; a chat is in a folder when it is listed in
+-- chat_folder_chats or matches one of the include rules
+CREATE TABLE IF NOT EXISTS chat_folders (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  include_groups BOOLEAN NOT NULL DEFAULT false,
+  include_direct BOOLEAN NOT NULL DEFAULT false,
+  unread_only BOOLEAN NOT NULL DEFAULT false,
+  exclude_muted BOOLEAN NOT NULL DEFAULT false,
+  position INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_folders_user_id_idx ON chat_folders (user_id);
+
+CREATE TABLE IF NOT EXISTS chat_folder_chats (
+  folder_id BIGINT NOT NULL REFERENCES chat_folders(id) ON DELETE CASCADE,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  PRIMARY KEY (folder_id, chat_id)
+);
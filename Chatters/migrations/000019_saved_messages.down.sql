@@ -0,0 +1,10 @@
+ALTER TABLE media_messages
+  DROP COLUMN IF EXISTS forward_sender_id,
+  DROP COLUMN IF EXISTS forward_chat_id,
+  DROP COLUMN IF EXISTS forward_media_id;
+ALTER TABLE messages
+  DROP COLUMN IF EXISTS forward_sender_id,
+  DROP COLUMN IF EXISTS forward_chat_id,
+  DROP COLUMN IF EXISTS forward_message_id;
+DELETE FROM chats WHERE saved_for IS NOT NULL;
+ALTER TABLE chats DROP COLUMN IF EXISTS saved_for;
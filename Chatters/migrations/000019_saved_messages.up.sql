@@ -0,0 +1,21 @@
+-- Every user gets a personal "Saved Messages" chat
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS saved_for UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE;
+
+INSERT INTO chats (is_group, saved_for)
+  SELECT false, id FROM users
+  ON CONFLICT (saved_for) DO NOTHING;
+INSERT INTO chat_members (chat_id, user_id)
+  SELECT c.id, c.saved_for FROM chats c
+  WHERE c.saved_for IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM chat_members m WHERE m.chat_id = c.id);
+
+-- Where a forwarded message or media item came from
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS forward_message_id INT,
+  ADD COLUMN IF NOT EXISTS forward_chat_id UUID,
+  ADD COLUMN IF NOT EXISTS forward_sender_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE media_messages
+  ADD COLUMN IF NOT EXISTS forward_media_id INT,
+  ADD COLUMN IF NOT EXISTS forward_chat_id UUID,
+  ADD COLUMN IF NOT EXISTS forward_sender_id UUID REFERENCES users(id) ON DELETE SET NULL;
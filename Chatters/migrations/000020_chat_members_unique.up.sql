@@ -0,0 +1,9 @@
+-- Nothing kept a user from being added to a chat twice; drop the extra
+-- rows before making (chat_id, user_id) unique.
+DELETE FROM chat_members a
+  USING chat_members b
+  WHERE a.chat_id = b.chat_id
+  AND a.user_id = b.user_id
+  AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS chat_members_chat_user_idx ON chat_members (chat_id, user_id);
@@ -0,0 +1,15 @@
+-- Starred messages and media. Stars go away with the message, and with
+-- the membership when the user leaves the chat.
+CREATE TABLE IF NOT EXISTS stars (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL,
+  chat_id UUID NOT NULL,
+  message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+  media_id INT REFERENCES media_messages(id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT now(),
+  FOREIGN KEY (chat_id, user_id) REFERENCES chat_members (chat_id, user_id) ON DELETE CASCADE,
+  CHECK ((message_id IS NULL) != (media_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS stars_user_message_idx ON stars (user_id, message_id);
+CREATE UNIQUE INDEX IF NOT EXISTS stars_user_media_idx ON stars (user_id, media_id);
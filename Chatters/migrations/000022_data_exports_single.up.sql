@@ -0,0 +1,12 @@
+-- At most one export in progress per user; older duplicates are given up
+UPDATE data_exports d SET status = 'failed', completed_at = now()
+  WHERE status IN ('pending', 'running')
+  AND EXISTS (
+    SELECT 1 FROM data_exports n
+    WHERE n.user_id = d.user_id
+    AND n.status IN ('pending', 'running')
+    AND (n.created_at, n.id) > (d.created_at, d.id)
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS data_exports_in_progress_idx
+  ON data_exports (user_id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,20 @@
+-- Each member's own read position, messages.status only tracks receipts
+ALTER TABLE chat_members
+  ADD COLUMN IF NOT EXISTS last_read_message_id INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_read_media_id INT NOT NULL DEFAULT 0;
+
+-- read up to the first message from someone else still unseen; media had
+-- no read state, so all of it counts as read
+UPDATE chat_members cm SET
+  last_read_message_id = COALESCE(
+    (SELECT MIN(m.id) - 1 FROM messages m
+     WHERE m.chat_id = cm.chat_id
+     AND m.sender_id != cm.user_id
+     AND m.status != 'seen'),
+    (SELECT MAX(m.id) FROM messages m WHERE m.chat_id = cm.chat_id),
+    0
+  ),
+  last_read_media_id = COALESCE(
+    (SELECT MAX(mm.id) FROM media_messages mm WHERE mm.chat_id = cm.chat_id),
+    0
+  );
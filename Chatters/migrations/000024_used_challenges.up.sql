@@ -0,0 +1,5 @@
+-- 2FA challenge tokens that already completed a login
+CREATE TABLE IF NOT EXISTS used_challenges (
+  jti TEXT PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);